@@ -1,517 +1,225 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
-	"go/format"
-	"io/fs"
+	"io"
 	"os"
-	"path/filepath"
-	"regexp"
 	"strings"
 
 	"github.com/urfave/cli/v2"
+	"github.com/ymzuiku/renamepkg/rename"
 )
 
 const version = "0.0.1"
 
-// replaceImports uses regex to replace imports
-// Replaces "oldImport" with "newImport", optionally adding alias if needAlias is true
-func replaceImports(src, oldImport, newImport, alias string, needAlias bool) string {
-	updated := src
-	// Escape special regex characters in oldImport
-	escapedOldImport := regexp.QuoteMeta(oldImport)
-	oldImportQuoted := `"` + oldImport + `"`
-
-	// Determine the replacement format based on whether alias is needed
-	var newImportReplacement string
-	if needAlias {
-		newImportReplacement = fmt.Sprintf(`%s "%s"`, alias, newImport)
-	} else {
-		newImportReplacement = fmt.Sprintf(`"%s"`, newImport)
+// options returns the rename options of the command line flags
+// With --report the progress messages are left out, only the report is printed
+// With --report ndjson every event is printed as soon as it happens
+func options(c *cli.Context) rename.Options {
+	opts := rename.Options{
+		Module:        c.String("mod"),
+		From:          c.String("from"),
+		To:            c.String("to"),
+		ModulePath:    c.String("module"),
+		Merge:         c.Bool("merge"),
+		Force:         c.Bool("force"),
+		RewriteRefs:   c.Bool("rewrite-refs"),
+		NestedModules: c.Bool("nested-modules"),
+		Gofmt:         c.Bool("gofmt"),
+		Exclude:       c.StringSlice("exclude"),
+		Include:       c.StringSlice("include"),
+		Git:           c.Bool("git"),
+		Commit:        c.Bool("commit"),
+		Verify:        c.Bool("verify"),
+		AllowDirty:    c.Bool("allow-dirty"),
+		Command:       os.Args,
+		Output:        os.Stdout,
 	}
-
-	// Check if the import exists in the file
-	if !strings.Contains(updated, oldImportQuoted) {
-		return updated
+	if c.String("report") != "" {
+		opts.Output = nil
 	}
-
-	// Process line by line to handle import blocks correctly
-	lines := strings.Split(updated, "\n")
-	inImportBlock := false
-	modified := false
-
-	for i, line := range lines {
-		trimmed := strings.TrimSpace(line)
-
-		// Detect import block start
-		if strings.HasPrefix(trimmed, "import (") {
-			inImportBlock = true
-		} else if inImportBlock && trimmed == ")" {
-			inImportBlock = false
-		}
-
-		// Check if this line contains the old import path
-		if strings.Contains(line, oldImportQuoted) {
-			// Skip comments
-			if strings.HasPrefix(trimmed, "//") || strings.HasPrefix(trimmed, "/*") {
-				continue
-			}
-
-			if inImportBlock {
-				// In import block: match "oldImport" or alias "oldImport"
-				// Pattern: optional alias, then the import path
-				linePattern := regexp.MustCompile(`(\s*)(\w+\s+)?"` + escapedOldImport + `"`)
-				lines[i] = linePattern.ReplaceAllStringFunc(line, func(match string) string {
-					submatches := linePattern.FindStringSubmatch(match)
-					if len(submatches) >= 3 {
-						indent := submatches[1]
-						existingAlias := strings.TrimSpace(submatches[2])
-
-						if needAlias {
-							// Always use new alias when needAlias is true
-							return fmt.Sprintf(`%s%s`, indent, newImportReplacement)
-						} else {
-							// When needAlias is false, keep existing alias if present
-							if existingAlias != "" {
-								return fmt.Sprintf(`%s%s "%s"`, indent, existingAlias, newImport)
-							}
-							// No existing alias, just replace path
-							return fmt.Sprintf(`%s"%s"`, indent, newImport)
-						}
-					}
-					return match
-				})
-				modified = true
-			} else {
-				// Single line import: import "oldImport" or import alias "oldImport"
-				// Pattern 1: without alias - must start with "import"
-				pattern1 := regexp.MustCompile(`^(\s*)import\s+"` + escapedOldImport + `"`)
-				if pattern1.MatchString(line) {
-					lines[i] = pattern1.ReplaceAllStringFunc(line, func(match string) string {
-						submatches := pattern1.FindStringSubmatch(match)
-						return submatches[1] + "import " + newImportReplacement
-					})
-					modified = true
-				} else {
-					// Pattern 2: with alias - must start with "import"
-					pattern2 := regexp.MustCompile(`^(\s*)import\s+(\w+\s+)"` + escapedOldImport + `"`)
-					if pattern2.MatchString(line) {
-						lines[i] = pattern2.ReplaceAllStringFunc(line, func(match string) string {
-							submatches := pattern2.FindStringSubmatch(match)
-							existingAlias := strings.TrimSpace(submatches[2])
-
-							if needAlias {
-								// Always use new alias when needAlias is true
-								return submatches[1] + "import " + newImportReplacement
-							} else {
-								// When needAlias is false, keep existing alias
-								return fmt.Sprintf(`%simport %s "%s"`, submatches[1], existingAlias, newImport)
-							}
-						})
-						modified = true
-					}
-				}
-			}
+	if c.String("report") == "ndjson" {
+		enc := json.NewEncoder(os.Stdout)
+		opts.Events = func(e rename.Event) {
+			// Write errors surface when the summary event is written
+			_ = enc.Encode(e)
 		}
 	}
-
-	if modified {
-		updated = strings.Join(lines, "\n")
-	}
-
-	return updated
+	return opts
 }
 
-// replaceModuleImports replaces all imports that start with oldModule with newModule
-// Preserves existing aliases, but does not add aliases if none exist
-func replaceModuleImports(src, oldModule, newModule string) string {
-	updated := src
-	escapedOldModule := regexp.QuoteMeta(oldModule)
-
-	// Check if any imports with oldModule exist
-	if !strings.Contains(updated, oldModule) {
-		return updated
+// run plans the rename described by opts and prints its diff in dry-run mode, otherwise applies it
+func run(c *cli.Context, opts rename.Options) error {
+	format := c.String("report")
+	if format != "" && format != "json" && format != "ndjson" {
+		return cli.Exit(fmt.Sprintf("Error: unknown report format %q, use json or ndjson", format), 1)
 	}
 
-	// Pattern to match imports starting with oldModule
-	// Matches: "oldModule/..." or alias "oldModule/..."
-	lines := strings.Split(updated, "\n")
-	inImportBlock := false
-	modified := false
-
-	for i, line := range lines {
-		trimmed := strings.TrimSpace(line)
-
-		// Detect import block start
-		if strings.HasPrefix(trimmed, "import (") {
-			inImportBlock = true
-		} else if inImportBlock && trimmed == ")" {
-			inImportBlock = false
-		}
-
-		// Check if this line contains an import with oldModule
-		if strings.Contains(line, oldModule) {
-			// Pattern 1: Single line import without alias: import "oldModule/..."
-			pattern1 := regexp.MustCompile(`(\s+import\s+)"(` + escapedOldModule + `/[^"]+)"`)
-			if pattern1.MatchString(line) {
-				line = pattern1.ReplaceAllStringFunc(line, func(match string) string {
-					submatches := pattern1.FindStringSubmatch(match)
-					if len(submatches) < 3 {
-						return match
-					}
-					oldImportPath := submatches[2]
-					newImportPath := strings.Replace(oldImportPath, oldModule, newModule, 1)
-					// Don't add alias for module rename, just replace the path
-					return fmt.Sprintf(`%s"%s"`, submatches[1], newImportPath)
-				})
-				modified = true
-			}
-
-			// Pattern 2: Single line import with alias: import alias "oldModule/..."
-			pattern2 := regexp.MustCompile(`(\s+import\s+)(\w+\s+)"(` + escapedOldModule + `/[^"]+)"`)
-			if pattern2.MatchString(line) {
-				line = pattern2.ReplaceAllStringFunc(line, func(match string) string {
-					submatches := pattern2.FindStringSubmatch(match)
-					if len(submatches) < 4 {
-						return match
-					}
-					alias := submatches[2]
-					oldImportPath := submatches[3]
-					newImportPath := strings.Replace(oldImportPath, oldModule, newModule, 1)
-					return fmt.Sprintf(`%s%s "%s"`, submatches[1], alias, newImportPath)
-				})
-				modified = true
-			}
-
-			// Pattern 3: In import block: "oldModule/..." or alias "oldModule/..."
-			if inImportBlock || strings.Contains(line, "import") {
-				// Match: optional alias, then "oldModule/..."
-				pattern3 := regexp.MustCompile(`(\s*)(\w+\s+)?"(` + escapedOldModule + `/[^"]+)"`)
-				if pattern3.MatchString(line) {
-					line = pattern3.ReplaceAllStringFunc(line, func(match string) string {
-						submatches := pattern3.FindStringSubmatch(match)
-						if len(submatches) < 4 {
-							return match
-						}
-						indent := submatches[1]
-						existingAlias := strings.TrimSpace(submatches[2])
-						oldImportPath := submatches[3]
-						newImportPath := strings.Replace(oldImportPath, oldModule, newModule, 1)
-
-						if existingAlias != "" {
-							// Keep existing alias
-							return fmt.Sprintf(`%s%s "%s"`, indent, existingAlias, newImportPath)
-						}
-
-						// Don't add alias for module rename, just replace the path
-						return fmt.Sprintf(`%s"%s"`, indent, newImportPath)
-					})
-					modified = true
-				}
-			}
+	changes, err := rename.Plan(c.Context, opts)
+	if err != nil {
+		if format != "" {
+			r := rename.NewReport()
+			r.DryRun = c.Bool("dry-run")
+			r.Error = err.Error()
+			return writeReport(format, r)
 		}
-
-		lines[i] = line
-	}
-
-	if modified {
-		updated = strings.Join(lines, "\n")
+		return cli.Exit(fmt.Sprintf("Error: %v", err), 1)
 	}
-
-	return updated
-}
-
-// readModuleFromGoMod reads the module path from go.mod file
-func readModuleFromGoMod() (string, error) {
-	data, err := os.ReadFile("go.mod")
-	if err != nil {
-		return "", fmt.Errorf("failed to read go.mod: %v", err)
+	if format != "" {
+		return runReport(c, format, changes)
 	}
-
-	lines := strings.Split(string(data), "\n")
-	for _, line := range lines {
-		trimmed := strings.TrimSpace(line)
-		if strings.HasPrefix(trimmed, "module ") {
-			// Extract module path after "module "
-			parts := strings.Fields(trimmed)
-			if len(parts) >= 2 {
-				return parts[1], nil
-			}
+	// A dry run shows the diff even with conflicts, listed after it
+	if c.Bool("dry-run") {
+		fmt.Println()
+		changes.WriteDiff(os.Stdout)
+		fmt.Printf("\nDry run: would process %d files and modify %d files.\n", changes.FilesProcessed(), len(changes.Files())+len(changes.GoMod()))
+		if err := changes.Err(); err != nil {
+			return cli.Exit(fmt.Sprintf("Error: %v", err), 1)
 		}
+		return nil
 	}
-
-	return "", fmt.Errorf("module declaration not found in go.mod")
-}
-
-// updateGoMod updates the module path in go.mod file
-func updateGoMod(newModule string) error {
-	data, err := os.ReadFile("go.mod")
-	if err != nil {
-		return fmt.Errorf("failed to read go.mod: %v", err)
+	if err := changes.Err(); err != nil {
+		return cli.Exit(fmt.Sprintf("Error: %v", err), 1)
 	}
-
-	lines := strings.Split(string(data), "\n")
-	modified := false
-
-	for i, line := range lines {
-		trimmed := strings.TrimSpace(line)
-		if strings.HasPrefix(trimmed, "module ") {
-			// Replace module path
-			parts := strings.Fields(trimmed)
-			if len(parts) >= 2 {
-				// Preserve the original indentation and format
-				indent := ""
-				for j, char := range line {
-					if char != ' ' && char != '\t' {
-						indent = line[:j]
-						break
-					}
-				}
-				lines[i] = indent + "module " + newModule
-				modified = true
-				break
-			}
-		}
+	if err := rename.Apply(changes); err != nil {
+		return cli.Exit(fmt.Sprintf("Error: %v", err), 1)
 	}
 
-	if !modified {
-		return fmt.Errorf("module declaration not found in go.mod")
+	// Only show alias refactoring hint if alias is needed
+	for _, i := range changes.Imports() {
+		if i.Alias != "" {
+			fmt.Printf("\nPlease search for: %s \"%s\"\n", i.Alias, i.New)
+			fmt.Printf("Then use F2 to refactor the alias '%s'.\n", i.Alias)
+		}
 	}
-
-	updated := strings.Join(lines, "\n")
-	return os.WriteFile("go.mod", []byte(updated), 0644)
+	return nil
 }
 
-// renameModule renames all imports from oldModule to newModule across all .go files
-func renameModule(oldModule, newModule string) {
-	oldModuleSlash := filepath.ToSlash(oldModule)
-	newModuleSlash := filepath.ToSlash(newModule)
-
-	fmt.Println("Rename module imports:")
-	fmt.Println("  ", oldModuleSlash, "→", newModuleSlash)
-
-	// Search all .go files in the project directory and replace import statements
-	var filesProcessed, filesModified int
-	err := filepath.Walk(".", func(path string, info fs.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-		if info.IsDir() || !strings.HasSuffix(path, ".go") {
-			return nil
-		}
-
-		// Skip files in vendor, node_modules, .git directories
-		if strings.Contains(path, "/vendor/") || strings.Contains(path, "/node_modules/") || strings.Contains(path, "/.git/") {
-			return nil
-		}
-
-		filesProcessed++
-		data, err := os.ReadFile(path)
-		if err != nil {
-			return err
-		}
-
-		originalContent := string(data)
-		updated := replaceModuleImports(originalContent, oldModuleSlash, newModuleSlash)
-		modified := updated != originalContent
-
-		if modified {
-			filesModified++
-			fmt.Printf("  Updated: %s\n", path)
-		}
-
-		// gofmt
-		formatted, err := format.Source([]byte(updated))
-		if err != nil {
-			fmt.Printf("Warning: cannot format %s: %v\n", path, err)
-			formatted = []byte(updated)
-		}
-
-		return os.WriteFile(path, formatted, info.Mode())
-	})
-
-	if err != nil {
-		fmt.Println("Error:", err)
-		os.Exit(1)
+// applyAction runs the package moves and the module rename listed in the plan file
+func applyAction(c *cli.Context) error {
+	if c.String("file") == "" {
+		return cli.Exit("Error: -f is required\nUsage: renamepkg apply -f moves.yaml", 1)
 	}
-
-	// Update go.mod file with new module path
-	if err := updateGoMod(newModuleSlash); err != nil {
-		fmt.Printf("Warning: failed to update go.mod: %v\n", err)
-	} else {
-		fmt.Printf("  Updated: go.mod\n")
+	f, err := rename.ReadMoveFile(c.String("file"))
+	if err != nil {
+		return cli.Exit(fmt.Sprintf("Error: %v", err), 1)
 	}
 
-	fmt.Printf("\nCompleted successfully. Processed %d files, modified %d files.\n", filesProcessed, filesModified)
+	opts := options(c)
+	opts.Module, opts.From, opts.To = f.Module, "", ""
+	opts.Moves = f.Moves
+	return run(c, opts)
 }
 
-func renamePackageAction(c *cli.Context) error {
-	from := c.String("from")
-	to := c.String("to")
-	mod := c.String("module")
-	force := c.Bool("force")
-
-	if from == "" || to == "" {
-		return cli.Exit("Error: -from and -to are required", 1)
+// runReport applies changes unless in dry-run mode and prints the report in format instead of the progress messages
+func runReport(c *cli.Context, format string, changes *rename.Changes) error {
+	err := changes.Err()
+	if err == nil && !c.Bool("dry-run") {
+		err = rename.Apply(changes)
 	}
 
-	// Read module from go.mod if not provided
-	var modulePath string
-	if mod == "" {
-		var err error
-		modulePath, err = readModuleFromGoMod()
-		if err != nil {
-			return cli.Exit(fmt.Sprintf("Error: %v", err), 1)
-		}
-	} else {
-		modulePath = mod
+	r := changes.Report()
+	r.DryRun = c.Bool("dry-run")
+	r.Applied = err == nil && !r.DryRun
+	if err != nil {
+		r.Error = err.Error()
 	}
+	return writeReport(format, r)
+}
 
-	// Extract package name from 'from' path (e.g., "di" from "internal/server/di")
-	// Only need alias if the last segment of the path changed
-	fromBase := filepath.Base(from)
-	toBase := filepath.Base(to)
-	needAlias := fromBase != toBase
-	alias := fromBase
-
-	oldFullPath := filepath.Join(from)
-	newFullPath := filepath.Join(to)
-
-	// Check if target directory exists
-	if _, err := os.Stat(newFullPath); err == nil {
-		if force {
-			fmt.Printf("Target directory %s exists, removing it (--force enabled)...\n", newFullPath)
-			if err := os.RemoveAll(newFullPath); err != nil {
-				return cli.Exit(fmt.Sprintf("Failed to remove target directory: %v", err), 1)
-			}
-		} else {
-			return cli.Exit(fmt.Sprintf("Error: Target directory %s already exists.\nUse -force to overwrite it.", newFullPath), 1)
+// writeReport prints r as json, or only its summary event for ndjson whose other events were
+// streamed already, failing the command if r has an error
+func writeReport(format string, r *rename.Report) error {
+	write := r.WriteJSON
+	if format == "ndjson" {
+		write = func(w io.Writer) error {
+			return json.NewEncoder(w).Encode(rename.Event{Type: "summary", Summary: r.Summary()})
 		}
 	}
-
-	// Ensure parent directories exist for the target path
-	newFullPathParent := filepath.Dir(newFullPath)
-	if err := os.MkdirAll(newFullPathParent, 0755); err != nil {
-		return cli.Exit(fmt.Sprintf("Failed to create parent directories for %s: %v", newFullPath, err), 1)
+	if err := write(os.Stdout); err != nil {
+		return cli.Exit(fmt.Sprintf("Error: %v", err), 1)
 	}
-
-	// Step 1: rename folder
-	if err := os.Rename(oldFullPath, newFullPath); err != nil {
-		return cli.Exit(fmt.Sprintf("Failed to rename folder: %v", err), 1)
+	if r.Error != "" {
+		return cli.Exit("", 1)
 	}
+	return nil
+}
 
-	// Build import paths
-	// Construct full import paths: modulePath/from -> modulePath/to
-	modSlash := filepath.ToSlash(modulePath)
-	fromSlash := filepath.ToSlash(from)
-	toSlash := filepath.ToSlash(to)
-
-	// Build full import paths
-	oldImport := modSlash + "/" + fromSlash
-	newImport := modSlash + "/" + toSlash
-
-	fmt.Println("Rename import:")
-	if needAlias {
-		fmt.Printf("  \"%s\" → %s \"%s\"\n", oldImport, alias, newImport)
-	} else {
-		fmt.Printf("  \"%s\" → \"%s\"\n", oldImport, newImport)
-	}
-
-	// Step 2: Search all .go files in the project directory (execution directory, not package directory)
-	// and replace import statements
-	var filesProcessed, filesModified int
-	err := filepath.Walk(".", func(path string, info fs.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-		if info.IsDir() || !strings.HasSuffix(path, ".go") {
-			return nil
-		}
-
-		// Skip files in vendor, node_modules, .git directories
-		if strings.Contains(path, "/vendor/") || strings.Contains(path, "/node_modules/") || strings.Contains(path, "/.git/") {
-			return nil
-		}
-
-		filesProcessed++
-		data, err := os.ReadFile(path)
-		if err != nil {
-			return err
-		}
-
-		originalContent := string(data)
-		updated := originalContent
-		modified := false
-
-		// Replace import using regex
-		updatedContent := replaceImports(originalContent, oldImport, newImport, alias, needAlias)
-		if updatedContent != originalContent {
-			updated = updatedContent
-			modified = true
-		}
-
-		// If inside the new package dir, update `package xxx`
-		if strings.HasPrefix(path, newFullPath) {
-			oldPkg := filepath.Base(from)
-			newPkg := filepath.Base(to)
-			// Use regex to replace package declaration
-			packagePattern := regexp.MustCompile(`^package\s+` + regexp.QuoteMeta(oldPkg) + `\s*$`)
-			lines := strings.Split(updated, "\n")
-			for i, line := range lines {
-				if packagePattern.MatchString(strings.TrimSpace(line)) {
-					lines[i] = strings.Replace(line, "package "+oldPkg, "package "+newPkg, 1)
-					modified = true
-					break
-				}
-			}
-			updated = strings.Join(lines, "\n")
-		}
-
-		if modified {
-			filesModified++
-			fmt.Printf("  Updated: %s\n", path)
-		}
-
-		// gofmt
-		formatted, err := format.Source([]byte(updated))
-		if err != nil {
-			fmt.Printf("Warning: cannot format %s: %v\n", path, err)
-			formatted = []byte(updated)
-		}
-
-		return os.WriteFile(path, formatted, info.Mode())
-	})
-
+func undoAction(c *cli.Context) error {
+	j, err := rename.Undo("", os.Stdout)
 	if err != nil {
 		return cli.Exit(fmt.Sprintf("Error: %v", err), 1)
 	}
 
-	fmt.Printf("\nCompleted successfully. Processed %d files, modified %d files.\n", filesProcessed, filesModified)
-
-	// Only show alias refactoring hint if alias is needed
-	if needAlias {
-		fmt.Printf("\nPlease search for: %s \"%s\"\n", alias, newImport)
-		fmt.Printf("Then use F2 to refactor the alias '%s'.\n", alias)
+	fmt.Printf("Undid: %s\n", strings.Join(j.Command, " "))
+	for i := len(j.Moves) - 1; i >= 0; i-- {
+		fmt.Printf("  Moved back: %s → %s\n", j.Moves[i].To, j.Moves[i].From)
+		if j.Moves[i].Replaced != "" {
+			fmt.Printf("  Restored: %s\n", j.Moves[i].To)
+		}
+	}
+	for _, f := range j.Files {
+		fmt.Printf("  Restored: %s\n", f.Path)
 	}
-
 	return nil
 }
 
-func renameModuleAction(c *cli.Context) error {
-	newMod := c.String("mod")
-
-	if newMod == "" {
-		return cli.Exit("Error: -mod is required", 1)
-	}
-
-	// Read old module from go.mod
-	oldMod, err := readModuleFromGoMod()
-	if err != nil {
-		return cli.Exit(fmt.Sprintf("Error: %v", err), 1)
+// renameFlags returns the flags shared by a single rename and apply
+func renameFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.BoolFlag{
+			Name:    "force",
+			Aliases: []string{"F"},
+			Usage:   "force: delete target directory if it exists",
+		},
+		&cli.BoolFlag{
+			Name:    "rewrite-refs",
+			Aliases: []string{"r"},
+			Usage:   "drop the alias and rewrite qualified references (e.g. di.Foo → difish.Foo)",
+		},
+		&cli.BoolFlag{
+			Name:    "dry-run",
+			Aliases: []string{"n"},
+			Usage:   "print a unified diff of the planned changes without touching the filesystem",
+		},
+		&cli.BoolFlag{
+			Name:  "nested-modules",
+			Usage: "also rewrite modules nested in subdirectories, each matched against its own go.mod",
+		},
+		&cli.StringSliceFlag{
+			Name:  "exclude",
+			Usage: "skip files and directories matching the glob, repeatable (e.g. --exclude 'gen/**')",
+		},
+		&cli.StringSliceFlag{
+			Name:  "include",
+			Usage: "process files matching the glob even if excluded, .gitignored or in testdata, repeatable",
+		},
+		&cli.BoolFlag{
+			Name:  "git",
+			Usage: "stage the move and the rewritten files with git (requires a clean working tree)",
+		},
+		&cli.BoolFlag{
+			Name:  "commit",
+			Usage: "like --git, then commit with a message listing the old and new import paths",
+		},
+		&cli.BoolFlag{
+			Name:  "verify",
+			Usage: "run go build and go vet after the rename and roll it back if they fail",
+		},
+		&cli.BoolFlag{
+			Name:  "allow-dirty",
+			Usage: "rename even if the touched files have uncommitted changes",
+		},
+		&cli.StringFlag{
+			Name:  "report",
+			Usage: "print a machine-readable report instead of the progress messages: json or ndjson (one event per line)",
+		},
+		&cli.BoolFlag{
+			Name:  "gofmt",
+			Usage: "gofmt every changed file as a whole (default: only reformat the edited declarations)",
+		},
 	}
-
-	renameModule(oldMod, newMod)
-	return nil
 }
 
 func main() {
@@ -519,7 +227,7 @@ func main() {
 		Name:    "renamepkg",
 		Usage:   "Rename Go packages and modules",
 		Version: version,
-		Flags: []cli.Flag{
+		Flags: append([]cli.Flag{
 			&cli.StringFlag{
 				Name:    "mod",
 				Aliases: []string{"m"},
@@ -540,9 +248,28 @@ func main() {
 				Usage: "module path in go.mod (optional, will be read from go.mod if not provided)",
 			},
 			&cli.BoolFlag{
-				Name:    "force",
-				Aliases: []string{"F"},
-				Usage:   "force: delete target directory if it exists",
+				Name:  "merge",
+				Usage: "merge into the target package if it exists: move the files in, take its package name and dedupe imports of both",
+			},
+		}, renameFlags()...),
+		Commands: []*cli.Command{
+			{
+				Name:      "apply",
+				Usage:     "move many packages, and optionally rename the module, as listed in a YAML plan file",
+				ArgsUsage: " ",
+				Flags: append([]cli.Flag{
+					&cli.StringFlag{
+						Name:    "file",
+						Aliases: []string{"f"},
+						Usage:   "plan file with the moves (e.g. moves.yaml)",
+					},
+				}, renameFlags()...),
+				Action: applyAction,
+			},
+			{
+				Name:   "undo",
+				Usage:  "revert the last rename recorded in " + rename.StateDir,
+				Action: undoAction,
 			},
 		},
 		Action: func(c *cli.Context) error {
@@ -552,11 +279,14 @@ func main() {
 				if c.String("from") != "" || c.String("to") != "" {
 					return cli.Exit("Error: Cannot use -from/-to with -mod\nUsage: renamepkg -mod github.com/pillar/doaddon", 1)
 				}
-				return renameModuleAction(c)
+				return run(c, options(c))
 			}
 
 			// Package rename mode: -from, -to (module is read from go.mod if not provided)
-			return renamePackageAction(c)
+			if c.String("from") == "" || c.String("to") == "" {
+				return cli.Exit("Error: -from and -to are required", 1)
+			}
+			return run(c, options(c))
 		},
 	}
 
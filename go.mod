@@ -2,9 +2,14 @@ module github.com/ymzuiku/renamepkg
 
 go 1.25.0
 
+require (
+	github.com/urfave/cli/v2 v2.27.7
+	golang.org/x/mod v0.40.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
 require (
 	github.com/cpuguy83/go-md2man/v2 v2.0.7 // indirect
 	github.com/russross/blackfriday/v2 v2.1.0 // indirect
-	github.com/urfave/cli/v2 v2.27.7 // indirect
 	github.com/xrash/smetrics v0.0.0-20240521201337-686a1a2994c1 // indirect
 )
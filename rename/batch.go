@@ -0,0 +1,387 @@
+package rename
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Move is a package directory moved by a batch rename
+// To is where the directory ends up in the final layout, packages nested in From move along
+// unless they have a move of their own
+type Move struct {
+	From string `yaml:"from"`
+	To   string `yaml:"to"`
+}
+
+// MoveFile is a batch rename read from a plan file
+type MoveFile struct {
+	Module string `yaml:"module"`
+	Moves  []Move `yaml:"moves"`
+}
+
+// ReadMoveFile reads a YAML plan file listing package moves and an optional module rename:
+//
+//	module: example.com/newmod
+//	moves:
+//	  - from: internal/server/di
+//	    to: internal/server/difish
+func ReadMoveFile(path string) (*MoveFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f MoveFile
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&f); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("invalid plan file %s: %v", path, err)
+	}
+	if f.Module == "" && len(f.Moves) == 0 {
+		return nil, fmt.Errorf("plan file %s has no module and no moves", path)
+	}
+	return &f, nil
+}
+
+// batchMove is a package move of a batch with its import paths and package names
+// The names are empty for a directory without Go files of its own
+type batchMove struct {
+	from, to             string
+	oldImport, newImport string
+	oldPkg, newPkg       string
+	replace              bool
+}
+
+// batchRename rewrites the imports of many package moves in a single pass over the files
+// Import paths follow the innermost moved package containing them, so nested moves win over their parents
+type batchRename struct {
+	moves       []*batchMove
+	rewriteRefs bool
+}
+
+// lookup returns the innermost move containing importPath and the new import path, or nil
+func (b *batchRename) lookup(importPath string) (*batchMove, string) {
+	var found *batchMove
+	for _, m := range b.moves {
+		if importPath != m.oldImport && !strings.HasPrefix(importPath, m.oldImport+"/") {
+			continue
+		}
+		if found == nil || len(m.oldImport) > len(found.oldImport) {
+			found = m
+		}
+	}
+	if found == nil {
+		return nil, importPath
+	}
+	return found, found.newImport + strings.TrimPrefix(importPath, found.oldImport)
+}
+
+// packageOf returns the move renaming the package of the file at path, or nil
+func (b *batchRename) packageOf(path string) *batchMove {
+	dir := filepath.Dir(path)
+	for _, m := range b.moves {
+		if m.from == dir && m.oldPkg != m.newPkg {
+			return m
+		}
+	}
+	return nil
+}
+
+// renamesPackage reports whether the package clause of the file at path changes
+func (b *batchRename) renamesPackage(path string) bool {
+	return b.packageOf(path) != nil
+}
+
+// rewrite rewrites the imports of the moved packages in the file at path and its package clause
+// An import without a name keeps the old package name as alias when it changes, unless rewriteRefs
+// rewrites the qualified references instead. It returns the conflicts of those rewrites, or an error
+// if the file cannot be parsed.
+func (b *batchRename) rewrite(path, src string) (string, []string, error) {
+	var renamed []*batchMove
+	rewriteSpecs := func(alias bool) (string, error) {
+		updated, err := rewriteImportSpecs([]byte(src), func(name, importPath string) (string, string) {
+			m, newPath := b.lookup(importPath)
+			if !alias || m == nil || importPath != m.oldImport || name != "" || m.oldPkg == m.newPkg {
+				return name, newPath
+			}
+			renamed = append(renamed, m)
+			return m.oldPkg, newPath
+		})
+		if err != nil {
+			return src, err
+		}
+		return string(updated), nil
+	}
+
+	out, err := rewriteSpecs(true)
+	if err != nil {
+		return src, nil, err
+	}
+	var conflicts []string
+	if b.rewriteRefs && len(renamed) > 0 {
+		// Drop the aliases and rewrite di.Foo → difish.Foo instead, all packages at once so they can swap names
+		var refs []qualifierRename
+		var newNames []string
+		for _, m := range renamed {
+			refs = append(refs, qualifierRename{importPath: m.newImport, oldName: m.oldPkg, newName: m.newPkg})
+			newNames = append(newNames, m.newPkg)
+		}
+		unaliased, _ := rewriteSpecs(false)
+		rewritten, err := renameQualifiers(unaliased, refs)
+		switch conflict := packageScopeConflict(path, rewritten, newNames...); {
+		case err != nil:
+			conflicts = append(conflicts, fmt.Sprintf("%s: %v, run without --rewrite-refs to keep the alias", path, err))
+		case rewritten != unaliased && conflict != "":
+			conflicts = append(conflicts, fmt.Sprintf("%s: %s, run without --rewrite-refs to keep the alias", path, conflict))
+		default:
+			out = rewritten
+		}
+	}
+	if m := b.packageOf(path); m != nil {
+		out = rewritePackageClause(out, m.oldPkg, m.newPkg)
+	}
+	return out, conflicts, nil
+}
+
+// planBatchRename plans the package moves of opts.Moves, and the module rename of opts.Module if set,
+// with a single pass over the files. The moves are validated together and ordered so that nested
+// and overlapping moves compose.
+func planBatchRename(ctx context.Context, plan *renamePlan, opts Options, filter *walkFilter) error {
+	oldModule := opts.ModulePath
+	if oldModule == "" {
+		var err error
+		if oldModule, err = readModuleFromGoMod(plan.path(".")); err != nil {
+			return err
+		}
+	}
+	oldModule = filepath.ToSlash(oldModule)
+	newModule := oldModule
+	if opts.Module != "" {
+		newModule = filepath.ToSlash(opts.Module)
+	}
+
+	nestedModules, err := findNestedModules(plan.path("."), filter)
+	if err != nil {
+		return err
+	}
+	moves, err := batchMoves(plan, opts.Moves, nestedModules, opts.Force)
+	if err != nil {
+		return err
+	}
+	ordered, staged := orderMoves(moves)
+	plan.staged = staged
+
+	if opts.Module != "" {
+		plan.logf("Rename module imports:\n")
+		plan.logf("   %s → %s\n", oldModule, newModule)
+		plan.addImports(
+			ImportRename{Old: oldModule, New: newModule},
+			ImportRename{Old: oldModule + "/...", New: newModule + "/..."})
+	}
+	plan.logf("Rename imports:\n")
+	for _, m := range moves {
+		m.oldImport = oldModule + "/" + filepath.ToSlash(plan.rel(m.from))
+		m.newImport = oldModule + "/" + filepath.ToSlash(plan.rel(m.to))
+		if hasGoFiles(m.from) {
+			m.oldPkg, m.newPkg = packageNames(plan, m.from, m.oldImport, m.newImport)
+		}
+		// The module rename applies on top of the move
+		newImport, _ := renameModulePath(m.newImport, oldModule, newModule, nil)
+		plan.addImportRename(m.oldImport, newImport, m.oldPkg, m.newPkg, opts.RewriteRefs, hasNestedPackages(m.from))
+	}
+
+	// A replaced target directory is removed, so its files are skipped
+	for _, m := range ordered {
+		plan.addMoves(dirMove{from: m.from, to: m.to, replace: m.replace})
+		if m.replace {
+			filter.skip = append(filter.skip, m.to)
+		}
+	}
+
+	batch := &batchRename{moves: moves, rewriteRefs: opts.RewriteRefs}
+	var files []goFile
+	if opts.Module != "" {
+		files, err = planModuleRename(ctx, plan, opts, filter, oldModule, batch)
+		if err != nil {
+			return err
+		}
+	} else {
+		roots := []string{plan.path(".")}
+		if opts.NestedModules {
+			for _, m := range nestedModules {
+				roots = append(roots, m.dir)
+			}
+		}
+		rw := &moduleRewrite{
+			oldModule: oldModule,
+			newModule: oldModule,
+			filter:    filter,
+			seen:      make(map[string]bool),
+			gofmt:     opts.Gofmt,
+			moves:     batch,
+		}
+		for _, root := range roots {
+			rootFiles, err := planModuleImports(ctx, plan, root, rw)
+			if err != nil {
+				return err
+			}
+			files = append(files, rootFiles...)
+		}
+	}
+
+	// Check the layout after the rename for broken imports before anything moves
+	importPath := func(dir, module string) string {
+		base, rel := module, filepath.ToSlash(plan.rel(dir))
+		if m := moduleOf(dir, nestedModules); m != nil {
+			base = m.path
+			if opts.NestedModules {
+				base, _ = renameModulePath(m.path, oldModule, module, nil)
+			}
+			rel = filepath.ToSlash(strings.TrimPrefix(strings.TrimPrefix(dir, m.dir), string(filepath.Separator)))
+		}
+		if rel == "." || rel == "" {
+			return base
+		}
+		return base + "/" + rel
+	}
+	check := &conflictCheck{plan: plan, files: files}
+	check.importPath = func(dir string) string {
+		return importPath(dir, newModule)
+	}
+	check.currentPath = func(dir string) string {
+		return importPath(dir, oldModule)
+	}
+	plan.addConflicts(check.conflicts()...)
+	return nil
+}
+
+// batchMoves validates moves against each other and the current layout
+// A target that already exists is replaced with force, unless another move vacates it
+// The directories of the returned moves are paths of plan.
+func batchMoves(plan *renamePlan, moves []Move, nestedModules []goModule, force bool) ([]*batchMove, error) {
+	var out []*batchMove
+	from := make(map[string]bool)
+	to := make(map[string]bool)
+	for _, mv := range moves {
+		if mv.From == "" || mv.To == "" {
+			return nil, fmt.Errorf("move %q → %q: from and to are required", mv.From, mv.To)
+		}
+		m := &batchMove{from: filepath.Clean(mv.From), to: filepath.Clean(mv.To)}
+		for _, dir := range []string{m.from, m.to} {
+			if dir == "." || filepath.IsAbs(dir) || dir == ".." || strings.HasPrefix(dir, ".."+string(filepath.Separator)) {
+				return nil, fmt.Errorf("move %s → %s: %s is not a package directory inside the module", m.from, m.to, dir)
+			}
+			if owner := moduleOf(plan.path(dir), nestedModules); owner != nil {
+				return nil, fmt.Errorf("move %s → %s: %s belongs to the nested module %s, batch moves stay in the root module", m.from, m.to, dir, owner.path)
+			}
+		}
+		m.from, m.to = plan.path(m.from), plan.path(m.to)
+		if m.from == m.to || within(m.to, m.from) {
+			return nil, fmt.Errorf("cannot move %s into itself (%s)", m.from, m.to)
+		}
+		if info, err := os.Stat(m.from); err != nil {
+			return nil, fmt.Errorf("failed to rename folder: %v", err)
+		} else if !info.IsDir() {
+			return nil, fmt.Errorf("%s is not a directory", m.from)
+		}
+		if from[m.from] {
+			return nil, fmt.Errorf("%s is moved twice", m.from)
+		}
+		if to[m.to] {
+			return nil, fmt.Errorf("more than one move targets %s", m.to)
+		}
+		from[m.from], to[m.to] = true, true
+		out = append(out, m)
+	}
+
+	// Every target must be free in the final layout
+	for _, m := range out {
+		if _, err := os.Stat(m.to); err == nil && finalDir(out, m.to) == m.to {
+			if !force {
+				return nil, fmt.Errorf("Target directory %s already exists.\nUse -force to overwrite it.", m.to)
+			}
+			m.replace = true
+		}
+		// A directory carried along by another move may land on the target too
+		for _, k := range out {
+			if !within(m.to, k.to) {
+				continue
+			}
+			rel, _ := filepath.Rel(k.to, m.to)
+			carried := filepath.Join(k.from, rel)
+			if _, err := os.Stat(carried); err == nil && finalDir(out, carried) == m.to {
+				return nil, fmt.Errorf("%s and %s would both end up in %s", m.from, carried, m.to)
+			}
+		}
+	}
+	return out, nil
+}
+
+// finalDir returns where dir ends up after moves, following the innermost move containing it
+func finalDir(moves []*batchMove, dir string) string {
+	moved, depth := dir, -1
+	for _, m := range moves {
+		if (dir == m.from || within(dir, m.from)) && len(m.from) > depth {
+			rel, _ := filepath.Rel(m.from, dir)
+			moved, depth = filepath.Join(m.to, rel), len(m.from)
+		}
+	}
+	return moved
+}
+
+// within reports whether path lies strictly inside dir
+func within(path, dir string) bool {
+	return strings.HasPrefix(path, dir+string(filepath.Separator))
+}
+
+// orderMoves returns moves in an order in which every directory can be renamed in place:
+// nested packages leave a directory before it moves, a target directory is in place before
+// the moves into it and a directory is vacated before another one takes its place.
+// Moves are kept in the given order where nothing else decides. Moves that swap or rotate
+// directories have no such order, they are returned as given and staged is set.
+func orderMoves(moves []*batchMove) (ordered []*batchMove, staged bool) {
+	n := len(moves)
+	next := make([][]int, n)
+	blockers := make([]int, n)
+	edge := func(first, then int) {
+		next[first] = append(next[first], then)
+		blockers[then]++
+	}
+	for i, a := range moves {
+		for j, b := range moves {
+			if i == j {
+				continue
+			}
+			if within(b.from, a.from) || a.to == b.from || within(a.to, b.from) || (a.replace && within(b.from, a.to)) {
+				edge(j, i)
+			}
+			if within(b.to, a.to) {
+				edge(i, j)
+			}
+		}
+	}
+
+	done := make([]bool, n)
+	for len(ordered) < n {
+		i := 0
+		for i < n && (done[i] || blockers[i] > 0) {
+			i++
+		}
+		if i == n {
+			return moves, true
+		}
+		done[i] = true
+		ordered = append(ordered, moves[i])
+		for _, j := range next[i] {
+			blockers[j]--
+		}
+	}
+	return ordered, false
+}
@@ -0,0 +1,238 @@
+package rename
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPlanBatchApplyUndo(t *testing.T) {
+	t.Chdir(t.TempDir())
+	original := map[string]string{
+		"go.mod":      "module example.com/app\n",
+		"a/b/b.go":    "package b\n\nfunc B() {}\n",
+		"a/b/c/c.go":  "package c\n\nfunc C() {}\n",
+		"x/x.go":      "package x\n\nimport (\n\t\"example.com/app/a/b\"\n\t\"example.com/app/a/b/c\"\n)\n\nfunc X() { b.B(); c.C() }\n",
+		"cmd/main.go": "package main\n\nimport (\n\t\"example.com/app/a/b/c\"\n\t\"example.com/app/x\"\n)\n\nfunc main() { c.C(); x.X() }\n",
+	}
+	writeTree(t, original)
+
+	// a/b/c leaves a/b before it moves, and x takes the place a/b leaves
+	changes, err := Plan(t.Context(), Options{
+		Module: "example.com/renamed",
+		Moves: []Move{
+			{From: "a/b", To: "lib/bee"},
+			{From: "a/b/c", To: "see"},
+			{From: "x", To: "a/b"},
+		},
+		AllowDirty: true,
+	})
+	if err != nil {
+		t.Fatalf("Plan() error = %v", err)
+	}
+	if err := changes.Err(); err != nil {
+		t.Fatalf("Err() = %v", err)
+	}
+	wantMoves := []DirMove{{From: "a/b/c", To: "see"}, {From: "a/b", To: "lib/bee"}, {From: "x", To: "a/b"}}
+	if len(changes.Moves()) != len(wantMoves) {
+		t.Fatalf("Moves = %+v, want %+v", changes.Moves(), wantMoves)
+	}
+	for i, m := range wantMoves {
+		if changes.Moves()[i] != m {
+			t.Errorf("Moves[%d] = %+v, want %+v", i, changes.Moves()[i], m)
+		}
+	}
+
+	if err := Apply(changes); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	got := readTree(t)
+	want := map[string]string{
+		"go.mod":       "module example.com/renamed\n",
+		"lib/bee/b.go": "package bee\n\nfunc B() {}\n",
+		"see/c.go":     "package see\n\nfunc C() {}\n",
+		"a/b/x.go":     "package b\n\nimport (\n\tb \"example.com/renamed/lib/bee\"\n\tc \"example.com/renamed/see\"\n)\n\nfunc X() { b.B(); c.C() }\n",
+		"cmd/main.go":  "package main\n\nimport (\n\tx \"example.com/renamed/a/b\"\n\tc \"example.com/renamed/see\"\n)\n\nfunc main() { c.C(); x.X() }\n",
+	}
+	for path, content := range want {
+		if got[path] != content {
+			t.Errorf("%s = %q, want %q", path, got[path], content)
+		}
+	}
+
+	if _, err := Undo("", nil); err != nil {
+		t.Fatalf("Undo() error = %v", err)
+	}
+	if err := os.RemoveAll(StateDir); err != nil {
+		t.Fatal(err)
+	}
+	assertTree(t, original)
+}
+
+func TestPlanBatchRotate(t *testing.T) {
+	t.Chdir(t.TempDir())
+	original := map[string]string{
+		"go.mod":       "module example.com/app\n",
+		"a/a.go":       "package a\n\nimport \"example.com/app/b\"\n\nfunc A() { b.B() }\n",
+		"a/sub/sub.go": "package sub\n\nfunc Sub() {}\n",
+		"b/b.go":       "package b\n\nfunc B() {}\n",
+		"c/c.go":       "package c\n\nimport \"example.com/app/a/sub\"\n\nfunc C() { sub.Sub() }\n",
+		"main.go":      "package main\n\nimport (\n\t\"example.com/app/a\"\n\t\"example.com/app/b\"\n\t\"example.com/app/c\"\n)\n\nfunc main() { a.A(); b.B(); c.C() }\n",
+	}
+	writeTree(t, original)
+
+	// a → b → c → a has no order that works in place
+	changes, err := Plan(t.Context(), Options{
+		Moves:       []Move{{From: "a", To: "b"}, {From: "b", To: "c"}, {From: "c", To: "a"}},
+		RewriteRefs: true,
+		AllowDirty:  true,
+	})
+	if err != nil {
+		t.Fatalf("Plan() error = %v", err)
+	}
+	if err := Apply(changes); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	want := map[string]string{
+		"go.mod":       "module example.com/app\n",
+		"b/a.go":       "package b\n\nimport \"example.com/app/c\"\n\nfunc A() { c.B() }\n",
+		"b/sub/sub.go": "package sub\n\nfunc Sub() {}\n",
+		"c/b.go":       "package c\n\nfunc B() {}\n",
+		"a/c.go":       "package a\n\nimport \"example.com/app/b/sub\"\n\nfunc C() { sub.Sub() }\n",
+		"main.go":      "package main\n\nimport (\n\t\"example.com/app/a\"\n\t\"example.com/app/b\"\n\t\"example.com/app/c\"\n)\n\nfunc main() { b.A(); c.B(); a.C() }\n",
+	}
+	got := readTree(t)
+	for path, content := range want {
+		if got[path] != content {
+			t.Errorf("%s = %q, want %q", path, got[path], content)
+		}
+	}
+	for path := range got {
+		if _, ok := want[path]; !ok && !strings.HasPrefix(path, StateDir+"/") {
+			t.Errorf("unexpected file %s", path)
+		}
+	}
+	if entries, _ := filepath.Glob(filepath.Join(StateDir, "staging-*")); len(entries) > 0 {
+		t.Errorf("staging directories left behind: %v", entries)
+	}
+
+	if _, err := Undo("", nil); err != nil {
+		t.Fatalf("Undo() error = %v", err)
+	}
+	if err := os.RemoveAll(StateDir); err != nil {
+		t.Fatal(err)
+	}
+	assertTree(t, original)
+}
+
+func TestPlanBatchRewriteRefs(t *testing.T) {
+	t.Chdir(t.TempDir())
+	writeTree(t, map[string]string{
+		"go.mod":      "module example.com/app\n",
+		"old/a.go":    "package old\n\nfunc A() {}\n",
+		"util/u.go":   "package util\n\nfunc U() {}\n",
+		"cmd/main.go": "package main\n\nimport (\n\t\"example.com/app/old\"\n\t\"example.com/app/util\"\n)\n\nfunc main() { old.A(); util.U() }\n",
+	})
+
+	changes, err := Plan(t.Context(), Options{
+		Moves:       []Move{{From: "old", To: "pkg/renamed"}, {From: "util", To: "pkg/helpers"}},
+		RewriteRefs: true,
+	})
+	if err != nil {
+		t.Fatalf("Plan() error = %v", err)
+	}
+	var main string
+	for _, f := range changes.Files() {
+		if f.Path == "cmd/main.go" {
+			main = string(f.After)
+		}
+	}
+	want := "package main\n\nimport (\n\t\"example.com/app/pkg/helpers\"\n\t\"example.com/app/pkg/renamed\"\n)\n\nfunc main() { renamed.A(); helpers.U() }\n"
+	if main != want {
+		t.Errorf("cmd/main.go = %q, want %q", main, want)
+	}
+}
+
+func TestPlanBatchErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		moves   []Move
+		wantErr string
+	}{
+		{
+			name:    "target exists",
+			moves:   []Move{{From: "a", To: "b"}},
+			wantErr: "Target directory b already exists",
+		},
+		{
+			name:    "moved twice",
+			moves:   []Move{{From: "a", To: "x"}, {From: "a/", To: "y"}},
+			wantErr: "a is moved twice",
+		},
+		{
+			name:    "same target",
+			moves:   []Move{{From: "a", To: "x"}, {From: "b", To: "x"}},
+			wantErr: "more than one move targets x",
+		},
+		{
+			name:    "into itself",
+			moves:   []Move{{From: "a", To: "a/inner"}},
+			wantErr: "cannot move a into itself",
+		},
+		{
+			name:    "outside the module",
+			moves:   []Move{{From: "a", To: "../a"}},
+			wantErr: "is not a package directory inside the module",
+		},
+		{
+			name:    "carried onto the target",
+			moves:   []Move{{From: "a", To: "x"}, {From: "b", To: "x/sub"}},
+			wantErr: "b and a/sub would both end up in x/sub",
+		},
+		{
+			name:    "missing source",
+			moves:   []Move{{From: "missing", To: "x"}},
+			wantErr: "failed to rename folder",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Chdir(t.TempDir())
+			writeTree(t, map[string]string{
+				"go.mod":       "module example.com/app\n",
+				"a/a.go":       "package a\n",
+				"a/sub/sub.go": "package sub\n",
+				"b/b.go":       "package b\n",
+			})
+			_, err := Plan(t.Context(), Options{Moves: tt.moves})
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Plan() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestReadMoveFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "moves.yaml")
+	if err := os.WriteFile(path, []byte("module: example.com/renamed\nmoves:\n  - from: a\n    to: b\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := ReadMoveFile(path)
+	if err != nil {
+		t.Fatalf("ReadMoveFile() error = %v", err)
+	}
+	if f.Module != "example.com/renamed" || len(f.Moves) != 1 || f.Moves[0] != (Move{From: "a", To: "b"}) {
+		t.Errorf("ReadMoveFile() = %+v", f)
+	}
+
+	for _, content := range []string{"", "moves:\n  - from: a\n    dest: b\n"} {
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ReadMoveFile(path); err == nil {
+			t.Errorf("ReadMoveFile(%q) error = nil", content)
+		}
+	}
+}
@@ -0,0 +1,248 @@
+package rename
+
+import (
+	"bytes"
+	"fmt"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// conflictCheck finds what a planned package rename would break before anything moves
+// It looks at every walked file as it will be after the rename: planned content at the moved path
+type conflictCheck struct {
+	plan  *renamePlan
+	files []goFile
+	// importPath returns the import path of the package in a directory, as laid out after the rename
+	importPath func(dir string) string
+	// currentPath returns the import path of the package in a directory before the rename
+	currentPath func(dir string) string
+
+	changed map[string][]byte   // planned content by current path
+	byDir   map[string][]string // current paths of the non-test files by directory after the rename
+	dirOf   map[string]string   // directory after the rename by import path
+	edges   map[string][]string // cached imports of a package by import path
+}
+
+// conflicts runs every check and returns the problems found, in a stable order
+func (c *conflictCheck) conflicts() []string {
+	c.changed = make(map[string][]byte)
+	for _, f := range c.plan.files {
+		c.changed[f.path] = f.after
+	}
+	c.byDir = make(map[string][]string)
+	c.dirOf = make(map[string]string)
+	c.edges = make(map[string][]string)
+	for _, f := range c.files {
+		if strings.HasSuffix(f.path, "_test.go") {
+			continue
+		}
+		dir := filepath.Dir(c.plan.movedPath(f.path))
+		if _, ok := c.byDir[dir]; !ok {
+			c.dirOf[c.importPath(dir)] = dir
+		}
+		c.byDir[dir] = append(c.byDir[dir], f.path)
+	}
+
+	var problems []string
+	for _, m := range c.plan.moves {
+		if m.to == m.from || strings.HasPrefix(m.to, m.from+string(filepath.Separator)) {
+			problems = append(problems, fmt.Sprintf("cannot move %s into itself (%s)", m.from, m.to))
+		}
+	}
+	problems = append(problems, c.replacedImporters()...)
+	problems = append(problems, c.internalViolations()...)
+	problems = append(problems, c.cycles()...)
+	return problems
+}
+
+// imports returns the import paths of the file at its current path, with their lines, as planned
+func (c *conflictCheck) imports(path string) ([]string, []int) {
+	src, ok := c.changed[path]
+	if !ok {
+		var err error
+		if src, err = os.ReadFile(path); err != nil {
+			return nil, nil
+		}
+	}
+	return parseImportPaths(path, src)
+}
+
+// parseImportPaths returns the import paths of src with their lines
+func parseImportPaths(path string, src []byte) ([]string, []int) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, path, src, parser.ImportsOnly)
+	if err != nil {
+		return nil, nil
+	}
+	var paths []string
+	var lines []int
+	for _, spec := range file.Imports {
+		if p, err := strconv.Unquote(spec.Path.Value); err == nil {
+			paths = append(paths, p)
+			lines = append(lines, fset.Position(spec.Pos()).Line)
+		}
+	}
+	return paths, lines
+}
+
+// isMoved reports whether importPath is a renamed package or one of the nested packages moving with it
+func (c *conflictCheck) isMoved(importPath string) bool {
+	for _, m := range c.plan.moves {
+		// An entry merged into a package changes that package
+		to := m.to
+		if m.merge {
+			to = filepath.Dir(m.to)
+		}
+		if p := c.importPath(to); importPath == p || strings.HasPrefix(importPath, p+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// replacedImporters reports files importing the target replaced with --force
+// They would silently get the moved package instead
+func (c *conflictCheck) replacedImporters() []string {
+	var problems []string
+	for _, m := range c.plan.moves {
+		if !m.replace {
+			continue
+		}
+		target := c.currentPath(m.to)
+		needle := []byte(target)
+		for _, f := range c.files {
+			// The current content tells which files import the target today
+			src, err := os.ReadFile(f.path)
+			if err != nil || !bytes.Contains(src, needle) {
+				continue
+			}
+			paths, lines := parseImportPaths(f.path, src)
+			for i, p := range paths {
+				if p == target || strings.HasPrefix(p, target+"/") {
+					problems = append(problems, fmt.Sprintf("%s:%d imports %s, which --force replaces with the moved package", f.path, lines[i], p))
+				}
+			}
+		}
+	}
+	return problems
+}
+
+// internalViolations reports imports that break the internal/ visibility rule after the rename:
+// importers of the moved packages and the moved packages' own imports
+func (c *conflictCheck) internalViolations() []string {
+	var problems []string
+	for _, f := range c.files {
+		newPath := c.plan.movedPath(f.path)
+		moved := newPath != f.path
+		if _, ok := c.changed[f.path]; !ok && !moved {
+			continue
+		}
+		importer := c.importPath(filepath.Dir(newPath))
+		paths, lines := c.imports(f.path)
+		for i, p := range paths {
+			if !moved && !c.isMoved(p) {
+				continue
+			}
+			if parent, ok := internalParent(p); ok && importer != parent && !strings.HasPrefix(importer, parent+"/") {
+				problems = append(problems, fmt.Sprintf("%s:%d: %s may not import %s, it is internal to %s", newPath, lines[i], importer, p, parent))
+			}
+		}
+	}
+	return problems
+}
+
+// internalParent returns the path whose subtree may import importPath under the internal/ rule
+// Like the go command, the last internal element is the one that counts
+func internalParent(importPath string) (string, bool) {
+	switch {
+	case strings.HasSuffix(importPath, "/internal"):
+		return strings.TrimSuffix(importPath, "/internal"), true
+	case strings.Contains(importPath, "/internal/"):
+		return importPath[:strings.LastIndex(importPath, "/internal/")], true
+	case importPath == "internal", strings.HasPrefix(importPath, "internal/"):
+		return "", true
+	}
+	return "", false
+}
+
+// cycles reports import cycles through the moved packages after the rename, test files aside
+func (c *conflictCheck) cycles() []string {
+	var starts []string
+	for path := range c.dirOf {
+		if c.isMoved(path) {
+			starts = append(starts, path)
+		}
+	}
+	sort.Strings(starts)
+
+	var problems []string
+	reported := make(map[string]bool)
+	for _, start := range starts {
+		cycle := c.findCycle(start)
+		if cycle == nil {
+			continue
+		}
+		// The same cycle is found from each of its packages, report it once
+		key := append([]string(nil), cycle[:len(cycle)-1]...)
+		sort.Strings(key)
+		if k := strings.Join(key, " "); !reported[k] {
+			reported[k] = true
+			problems = append(problems, "import cycle: "+strings.Join(cycle, " → "))
+		}
+	}
+	return problems
+}
+
+// findCycle returns a path of imports leading from start back to start, or nil
+func (c *conflictCheck) findCycle(start string) []string {
+	visited := make(map[string]bool)
+	var stack []string
+	var visit func(pkg string) bool
+	visit = func(pkg string) bool {
+		stack = append(stack, pkg)
+		for _, dep := range c.packageImports(pkg) {
+			if dep == start {
+				stack = append(stack, dep)
+				return true
+			}
+			if !visited[dep] {
+				visited[dep] = true
+				if visit(dep) {
+					return true
+				}
+			}
+		}
+		stack = stack[:len(stack)-1]
+		return false
+	}
+	if visit(start) {
+		return stack
+	}
+	return nil
+}
+
+// packageImports returns the imports of a package that are walked packages themselves
+func (c *conflictCheck) packageImports(importPath string) []string {
+	if deps, ok := c.edges[importPath]; ok {
+		return deps
+	}
+	seen := make(map[string]bool)
+	var deps []string
+	for _, path := range c.byDir[c.dirOf[importPath]] {
+		paths, _ := c.imports(path)
+		for _, p := range paths {
+			if _, ok := c.dirOf[p]; ok && !seen[p] {
+				seen[p] = true
+				deps = append(deps, p)
+			}
+		}
+	}
+	sort.Strings(deps)
+	c.edges[importPath] = deps
+	return deps
+}
@@ -0,0 +1,139 @@
+package rename
+
+import (
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// checkConflicts walks the working directory and runs the conflict checks of plan in module example.com/app
+func checkConflicts(t *testing.T, plan *renamePlan) []string {
+	t.Helper()
+	var files []goFile
+	err := walkGoFiles(".", nil, func(path string, info fs.FileInfo) error {
+		files = append(files, goFile{path: path, info: info})
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	check := &conflictCheck{plan: plan, files: files}
+	check.importPath = func(dir string) string {
+		if dir == "." {
+			return "example.com/app"
+		}
+		return "example.com/app/" + filepath.ToSlash(dir)
+	}
+	check.currentPath = check.importPath
+	return check.conflicts()
+}
+
+func TestConflictsInternal(t *testing.T) {
+	t.Chdir(t.TempDir())
+	writeTree(t, map[string]string{
+		"go.mod":              "module example.com/app\n",
+		"a/internal/x/x.go":   "package x\n",
+		"a/b/b.go":            "package b\n\nimport \"example.com/app/a/internal/x\"\n",
+		"a/internal/y/y.go":   "package y\n",
+		"a/internal/y/y2.go":  "package y\n\nimport \"example.com/app/a/internal/x\"\n",
+		"cmd/main.go":         "package main\n\nimport \"example.com/app/a/b\"\n",
+		"cmd/tool/tool.go":    "package main\n",
+		"a/internal/x/x2.go":  "package x\n\nimport \"fmt\"\n",
+		"a/internal/x/doc.go": "// Package x is internal\npackage x\n",
+	})
+
+	// Moving the importer out of a/ loses access to a/internal/x
+	plan := &renamePlan{moves: []dirMove{{from: "a/b", to: "b"}}}
+	plan.files = []fileChange{{path: "cmd/main.go", newPath: "cmd/main.go", after: []byte("package main\n\nimport \"example.com/app/b\"\n")}}
+	got := checkConflicts(t, plan)
+	if len(got) != 1 || !strings.Contains(got[0], "b/b.go:3: example.com/app/b may not import example.com/app/a/internal/x") {
+		t.Errorf("conflicts = %q, want the moved importer reported", got)
+	}
+
+	// Moving the internal package under another internal parent locks out its importers
+	plan = &renamePlan{moves: []dirMove{{from: "a/internal/x", to: "a/internal/y/internal/x"}}}
+	plan.files = []fileChange{
+		{path: "a/b/b.go", newPath: "a/b/b.go", after: []byte("package b\n\nimport \"example.com/app/a/internal/y/internal/x\"\n")},
+		{path: "a/internal/y/y2.go", newPath: "a/internal/y/y2.go", after: []byte("package y\n\nimport \"example.com/app/a/internal/y/internal/x\"\n")},
+	}
+	got = checkConflicts(t, plan)
+	if len(got) != 1 || !strings.HasPrefix(got[0], "a/b/b.go:3:") {
+		t.Errorf("conflicts = %q, want only a/b/b.go reported", got)
+	}
+
+	// Moving the internal package out of internal/ is always fine
+	plan = &renamePlan{moves: []dirMove{{from: "a/internal/x", to: "x"}}}
+	plan.files = []fileChange{{path: "a/b/b.go", newPath: "a/b/b.go", after: []byte("package b\n\nimport \"example.com/app/x\"\n")}}
+	if got := checkConflicts(t, plan); len(got) != 0 {
+		t.Errorf("conflicts = %q, want none", got)
+	}
+}
+
+func TestConflictsForceReplace(t *testing.T) {
+	t.Chdir(t.TempDir())
+	writeTree(t, map[string]string{
+		"go.mod":       "module example.com/app\n",
+		"old/old.go":   "package old\n\nimport \"example.com/app/user\"\n",
+		"user/user.go": "package user\n\nimport \"example.com/app/target\"\n",
+		"target/t.go":  "package target\n",
+	})
+
+	plan := &renamePlan{moves: []dirMove{{from: "old", to: "target", replace: true}}}
+	got := checkConflicts(t, plan)
+	want := []string{
+		"user/user.go:3 imports example.com/app/target, which --force replaces with the moved package",
+		"import cycle: example.com/app/target → example.com/app/user → example.com/app/target",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("conflicts = %q, want %q", got, want)
+	}
+}
+
+func TestConflictsMoveIntoItself(t *testing.T) {
+	t.Chdir(t.TempDir())
+	writeTree(t, map[string]string{"go.mod": "module example.com/app\n", "old/a.go": "package old\n"})
+
+	plan := &renamePlan{moves: []dirMove{{from: "old", to: "old/sub"}}}
+	got := checkConflicts(t, plan)
+	if len(got) != 1 || !strings.Contains(got[0], "into itself") {
+		t.Errorf("conflicts = %q, want the move into itself reported", got)
+	}
+}
+
+func TestInternalParent(t *testing.T) {
+	tests := []struct {
+		path   string
+		parent string
+		ok     bool
+	}{
+		{"example.com/app/internal", "example.com/app", true},
+		{"example.com/app/internal/x", "example.com/app", true},
+		{"example.com/app/a/internal/x/internal/y", "example.com/app/a/internal/x", true},
+		{"internal/x", "", true},
+		{"example.com/app/internals/x", "", false},
+		{"example.com/app/x", "", false},
+	}
+	for _, tt := range tests {
+		parent, ok := internalParent(tt.path)
+		if parent != tt.parent || ok != tt.ok {
+			t.Errorf("internalParent(%q) = %q, %v, want %q, %v", tt.path, parent, ok, tt.parent, tt.ok)
+		}
+	}
+}
+
+func TestRunPlanRefusesConflicts(t *testing.T) {
+	t.Chdir(t.TempDir())
+	original := map[string]string{"old/a.go": "package old\n"}
+	writeTree(t, original)
+
+	plan := &renamePlan{
+		moves:     []dirMove{{from: "old", to: "new"}},
+		conflicts: []string{"cmd/main.go: identifier \"new\" already used by variable"},
+	}
+	err := runPlan(plan, runOptions{allowDirty: true})
+	if err == nil || !strings.Contains(err.Error(), "cmd/main.go") {
+		t.Errorf("runPlan() error = %v, want the conflict listed", err)
+	}
+	assertTree(t, original)
+}
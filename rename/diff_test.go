@@ -0,0 +1,78 @@
+package rename
+
+import "testing"
+
+func TestUnifiedDiff(t *testing.T) {
+	tests := []struct {
+		name     string
+		a, b     string
+		expected string
+	}{
+		{
+			name:     "equal",
+			a:        "package main\n",
+			b:        "package main\n",
+			expected: "",
+		},
+		{
+			name: "single change with context",
+			a:    "package main\n\nimport \"example.com/app/di\"\n\nfunc main() {\n}\n",
+			b:    "package main\n\nimport di \"example.com/app/difish\"\n\nfunc main() {\n}\n",
+			// Unchanged blank lines keep their leading space
+			expected: "--- a/main.go\n+++ b/main.go\n@@ -1,6 +1,6 @@\n" +
+				" package main\n \n-import \"example.com/app/di\"\n+import di \"example.com/app/difish\"\n \n func main() {\n }\n",
+		},
+		{
+			name: "separate hunks",
+			a:    "a\nb\nc\nd\ne\nf\ng\nh\ni\nj\nk\n",
+			b:    "A\nb\nc\nd\ne\nf\ng\nh\ni\nj\nK\n",
+			expected: `--- a/main.go
++++ b/main.go
+@@ -1,4 +1,4 @@
+-a
++A
+ b
+ c
+ d
+@@ -8,4 +8,4 @@
+ h
+ i
+ j
+-k
++K
+`,
+		},
+		{
+			name: "insertion and missing trailing newline",
+			a:    "a\nb",
+			b:    "a\nx\nb",
+			expected: `--- a/main.go
++++ b/main.go
+@@ -1,2 +1,3 @@
+ a
++x
+ b
+\ No newline at end of file
+`,
+		},
+		{
+			name: "empty file",
+			a:    "",
+			b:    "a\n",
+			expected: `--- a/main.go
++++ b/main.go
+@@ -0,0 +1,1 @@
++a
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := unifiedDiff("a/main.go", "b/main.go", []byte(tt.a), []byte(tt.b))
+			if result != tt.expected {
+				t.Errorf("unifiedDiff() = \n%v\n, want \n%v", result, tt.expected)
+			}
+		})
+	}
+}
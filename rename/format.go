@@ -0,0 +1,119 @@
+package rename
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+)
+
+// formatUpdated formats the rewritten content of a file
+// With gofmt the whole file is formatted, otherwise only the declarations the rename touched
+// If the file cannot be formatted, after is returned unformatted along with the error
+func formatUpdated(path string, before, after []byte, gofmt bool) ([]byte, error) {
+	if !gofmt {
+		formatted, err := formatChangedDecls(before, after)
+		if err != nil {
+			return formatted, fmt.Errorf("cannot format %s: %v", path, err)
+		}
+		return formatted, nil
+	}
+
+	formatted, err := format.Source(after)
+	if err != nil {
+		return after, fmt.Errorf("cannot format %s: %v", path, err)
+	}
+	return formatted, nil
+}
+
+// formatChangedDecls gofmts the top-level declarations of after that contain lines changed from before,
+// or lines deleted from them
+// Everything outside those declarations is kept byte-for-byte
+// Touched import declarations get their imports sorted, as gofmt only sorts them in a whole file
+// Declarations that fail to format are left as they are and their errors returned
+func formatChangedDecls(before, after []byte) ([]byte, error) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "", after, parser.ParseComments)
+	if err != nil {
+		return after, err
+	}
+
+	// Line numbers (1-based) of after that differ from before, and of the lines following
+	// deleted ones
+	changed := make(map[int]bool)
+	deleted := make(map[int]bool)
+	for _, op := range diffLines(splitLines(before), splitLines(after)) {
+		switch op.kind {
+		case '+':
+			changed[op.b+1] = true
+		case '-':
+			deleted[op.b+1] = true
+		}
+	}
+
+	type touchedDecl struct {
+		index      int
+		start, end int
+		imports    bool
+	}
+	var touched []touchedDecl
+	sortImports := false
+	for i, decl := range file.Decls {
+		start, end := fset.Position(decl.Pos()), fset.Position(decl.End())
+		for line := start.Line; line <= end.Line; line++ {
+			// Lines deleted right before a declaration are outside of it
+			if changed[line] || deleted[line] && line > start.Line {
+				gen, ok := decl.(*ast.GenDecl)
+				imports := ok && gen.Tok == token.IMPORT
+				sortImports = sortImports || imports
+				touched = append(touched, touchedDecl{index: i, start: start.Offset, end: end.Offset, imports: imports})
+				break
+			}
+		}
+	}
+
+	var sorted *ast.File
+	var sortedSrc []byte
+	if sortImports {
+		if sorted, sortedSrc, err = sortFileImports(fset, file); err != nil {
+			return after, err
+		}
+	}
+
+	var edits []edit
+	var errs []error
+	for _, d := range touched {
+		src := after[d.start:d.end]
+		var formatted []byte
+		if d.imports {
+			decl := sorted.Decls[d.index]
+			formatted = sortedSrc[decl.Pos()-sorted.FileStart : decl.End()-sorted.FileStart]
+		} else if formatted, err = format.Source(src); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if !bytes.Equal(formatted, src) {
+			edits = append(edits, edit{start: d.start, end: d.end, text: string(formatted)})
+		}
+	}
+
+	return applyEdits(after, edits), errors.Join(errs...)
+}
+
+// sortFileImports sorts the imports of file in place and returns the gofmt'd file, parsed again
+// so its declarations line up with the ones of file
+func sortFileImports(fset *token.FileSet, file *ast.File) (*ast.File, []byte, error) {
+	ast.SortImports(fset, file)
+	var buf bytes.Buffer
+	if err := format.Node(&buf, fset, file); err != nil {
+		return nil, nil, err
+	}
+	sorted, err := parser.ParseFile(token.NewFileSet(), "", buf.Bytes(), parser.ParseComments)
+	if err != nil {
+		return nil, nil, err
+	}
+	return sorted, buf.Bytes(), nil
+}
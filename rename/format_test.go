@@ -0,0 +1,141 @@
+package rename
+
+import (
+	"go/format"
+	"strings"
+	"testing"
+)
+
+func TestFormatChangedDecls(t *testing.T) {
+	tests := []struct {
+		name     string
+		before   string
+		after    string
+		expected string
+		wantErr  bool
+	}{
+		{
+			name:     "declaration with a deleted line",
+			before:   "package main\n\nimport (\n\t\"fmt\"\n\n\t\"example.com/app/b\"\n)\n\nfunc  untouched()  {\n}\n",
+			after:    "package main\n\nimport (\n\t\"fmt\"\n\n)\n\nfunc  untouched()  {\n}\n",
+			expected: "package main\n\nimport (\n\t\"fmt\"\n)\n\nfunc  untouched()  {\n}\n",
+		},
+		{
+			name: "only the edited import declaration is formatted",
+			before: `package main
+
+import (
+	"example.com/app/di"
+)
+
+func  untouched()  {
+}
+`,
+			after: `package main
+
+import (
+	di   "example.com/app/difish"
+)
+
+func  untouched()  {
+}
+`,
+			expected: `package main
+
+import (
+	di "example.com/app/difish"
+)
+
+func  untouched()  {
+}
+`,
+		},
+		{
+			name: "edited function is realigned",
+			before: `package main
+
+type server struct {
+	c  *di.Container // container
+	id int           // id
+}
+
+var  x  = 1
+`,
+			after: `package main
+
+type server struct {
+	c  *difish.Container // container
+	id int           // id
+}
+
+var  x  = 1
+`,
+			expected: `package main
+
+type server struct {
+	c  *difish.Container // container
+	id int               // id
+}
+
+var  x  = 1
+`,
+		},
+		{
+			name:     "reordered imports are sorted",
+			before:   "package main\n\nimport (\n\t\"example.com/app/a\" // a\n\t\"example.com/app/b\"\n\n\t\"fmt\"\n)\n\nfunc  untouched()  {}\n",
+			after:    "package main\n\nimport (\n\t\"example.com/app/b\" // a\n\t\"example.com/app/a\"\n\n\t\"fmt\"\n)\n\nfunc  untouched()  {}\n",
+			expected: "package main\n\nimport (\n\t\"example.com/app/a\"\n\t\"example.com/app/b\" // a\n\n\t\"fmt\"\n)\n\nfunc  untouched()  {}\n",
+		},
+		{
+			name:     "unparsable file is left alone",
+			before:   "package main\n\nfunc {\n",
+			after:    "package main\n\nfunc  {\n",
+			expected: "package main\n\nfunc  {\n",
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := formatChangedDecls([]byte(tt.before), []byte(tt.after))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("formatChangedDecls() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if result := string(got); result != tt.expected {
+				t.Errorf("formatChangedDecls() = \n%v\n, want \n%v", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestPlanSwapIsGofmtClean(t *testing.T) {
+	t.Chdir(t.TempDir())
+	writeTree(t, map[string]string{
+		"go.mod":      "module example.com/app\n",
+		"a/a.go":      "package a\n\nfunc A() {}\n",
+		"b/b.go":      "package b\n\nfunc B() {}\n",
+		"cmd/main.go": "package main\n\nimport (\n\t\"example.com/app/a\"\n\t\"example.com/app/b\"\n)\n\nfunc main() { a.A(); b.B() }\n",
+	})
+
+	changes, err := Plan(t.Context(), Options{Moves: []Move{{From: "a", To: "b"}, {From: "b", To: "a"}}, RewriteRefs: true})
+	if err != nil {
+		t.Fatalf("Plan() error = %v", err)
+	}
+	rewritten := 0
+	for _, f := range changes.Files() {
+		if !strings.HasSuffix(f.Path, ".go") {
+			continue
+		}
+		rewritten++
+		formatted, err := format.Source(f.After)
+		if err != nil {
+			t.Fatalf("format.Source(%s) error = %v", f.Path, err)
+		}
+		if string(formatted) != string(f.After) {
+			t.Errorf("%s is not gofmt-clean:\n%s", f.Path, f.After)
+		}
+	}
+	if rewritten == 0 {
+		t.Error("Plan() rewrote no files")
+	}
+}
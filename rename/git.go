@@ -0,0 +1,218 @@
+package rename
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// gitOptions controls --git mode: the rename is staged with the local git binary and
+// optionally committed with message
+type gitOptions struct {
+	commit  bool
+	message string
+}
+
+// runGit runs git with args in dir and returns its trimmed standard output
+func runGit(dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if errors.Is(err, exec.ErrNotFound) {
+			return "", fmt.Errorf("git not found in PATH")
+		}
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("git %s: %s", args[0], msg)
+		}
+		return "", fmt.Errorf("git %s: %v", args[0], err)
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// gitCheckClean makes sure dir is inside a git work tree without staged or unstaged changes
+// to tracked files
+func gitCheckClean(dir string) error {
+	if _, err := runGit(dir, "rev-parse", "--is-inside-work-tree"); err != nil {
+		return fmt.Errorf("--git needs a git repository: %v", err)
+	}
+	status, err := runGit(dir, "status", "--porcelain", "--untracked-files=no")
+	if err != nil {
+		return err
+	}
+	if status != "" {
+		return fmt.Errorf("--git needs a clean working tree, commit or stash first or use --allow-dirty:\n%s", status)
+	}
+	return nil
+}
+
+// gitStage stages the directory moves and file changes of an applied plan and commits them
+// if opts asks for it. Paths ignored by git are left out. Git runs in the module root of plan.
+// On rollback the index is reset for the staged paths, a created commit is never undone.
+func gitStage(tx *transaction, plan *renamePlan, opts *gitOptions) error {
+	dir := plan.path(".")
+	var removed, added []string
+	for _, m := range plan.moves {
+		removed = append(removed, plan.rel(m.from))
+		added = append(added, plan.rel(m.to))
+	}
+	for _, f := range plan.files {
+		added = append(added, plan.rel(f.newPath))
+	}
+	added, err := gitDropIgnored(dir, added)
+	if err != nil {
+		return err
+	}
+
+	tx.onUndo(func() error {
+		_, err := runGit(dir, append([]string{"reset", "-q", "--"}, append(removed, added...)...)...)
+		return err
+	})
+	if len(removed) > 0 {
+		if _, err := runGit(dir, append([]string{"rm", "-r", "-q", "--cached", "--ignore-unmatch", "--"}, removed...)...); err != nil {
+			return err
+		}
+	}
+	if len(added) > 0 {
+		if _, err := runGit(dir, append([]string{"add", "-A", "--"}, added...)...); err != nil {
+			return err
+		}
+	}
+
+	if !opts.commit {
+		return nil
+	}
+	_, err = runGit(dir, "commit", "-q", "-m", opts.message)
+	return err
+}
+
+// gitDropIgnored returns paths, relative to dir, without the ones git ignores, git add refuses those
+func gitDropIgnored(dir string, paths []string) ([]string, error) {
+	if len(paths) == 0 {
+		return nil, nil
+	}
+	cmd := exec.Command("git", append([]string{"check-ignore", "--"}, paths...)...)
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+		// None of the paths is ignored
+		return paths, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("git check-ignore: %v", err)
+	}
+
+	ignored := make(map[string]bool)
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		ignored[line] = true
+	}
+	var kept []string
+	for _, p := range paths {
+		if !ignored[p] {
+			kept = append(kept, p)
+		}
+	}
+	return kept, nil
+}
+
+// commitMessage returns the generated commit message of a rename, listing every old and new import path
+func commitMessage(subject string, renames []ImportRename) string {
+	var b strings.Builder
+	b.WriteString(subject)
+	b.WriteString("\n\nImport paths:\n")
+	for _, r := range renames {
+		fmt.Fprintf(&b, "  %s -> %s\n", r.Old, r.New)
+	}
+	return b.String()
+}
+
+// gitDirtyPaths returns the root of the repository holding dir and the files, relative to it,
+// with staged, unstaged or untracked changes
+func gitDirtyPaths(dir string) (string, []string, error) {
+	top, err := runGit(dir, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return "", nil, err
+	}
+	// Entries start with a space for files changed in the work tree only, so the output is not trimmed
+	cmd := exec.Command("git", "status", "--porcelain", "-z", "--untracked-files=all")
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		return "", nil, fmt.Errorf("git status: %v", err)
+	}
+
+	var paths []string
+	entries := strings.Split(string(out), "\x00")
+	for i := 0; i < len(entries); i++ {
+		entry := entries[i]
+		if len(entry) < 4 {
+			continue
+		}
+		paths = append(paths, entry[3:])
+		// Renames and copies are followed by their original path
+		if entry[0] == 'R' || entry[0] == 'C' {
+			i++
+			if i < len(entries) {
+				paths = append(paths, entries[i])
+			}
+		}
+	}
+	return top, paths, nil
+}
+
+// checkDirty refuses to apply plan if a file it rewrites or moves has uncommitted changes,
+// they would get mixed with the rename. Outside a git repository the check is skipped with a warning.
+func checkDirty(plan *renamePlan) error {
+	top, dirty, err := gitDirtyPaths(plan.path("."))
+	if err != nil {
+		plan.warnf("skipping the uncommitted changes check, not a git repository (%v)", err)
+		return nil
+	}
+	root, err := filepath.Abs(plan.path("."))
+	if err != nil {
+		return err
+	}
+	if real, err := filepath.EvalSymlinks(root); err == nil {
+		root = real
+	}
+
+	files := make(map[string]bool)
+	var dirs []string
+	for _, f := range plan.files {
+		files[filepath.Join(root, plan.rel(f.path))] = true
+	}
+	for _, m := range plan.moves {
+		dirs = append(dirs, filepath.Join(root, plan.rel(m.from)))
+		if m.replace {
+			dirs = append(dirs, filepath.Join(root, plan.rel(m.to)))
+		}
+	}
+
+	var affected []string
+	for _, p := range dirty {
+		abs := filepath.Join(top, filepath.FromSlash(p))
+		hit := files[abs]
+		for _, dir := range dirs {
+			hit = hit || abs == dir || strings.HasPrefix(abs, dir+string(filepath.Separator))
+		}
+		if !hit {
+			continue
+		}
+		if rel, err := filepath.Rel(root, abs); err == nil {
+			abs = plan.path(rel)
+		}
+		affected = append(affected, abs)
+	}
+	if len(affected) > 0 {
+		sort.Strings(affected)
+		return fmt.Errorf("uncommitted changes in files touched by the rename, commit or stash them first or use --allow-dirty:\n  %s", strings.Join(affected, "\n  "))
+	}
+	return nil
+}
@@ -0,0 +1,188 @@
+package rename
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+// initGitRepo turns the current directory into a git repository with files committed
+func initGitRepo(t *testing.T, files map[string]string) {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	t.Setenv("GIT_CONFIG_GLOBAL", "/dev/null")
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+	writeTree(t, files)
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"config", "user.name", "test"},
+		{"config", "user.email", "test@example.com"},
+		{"add", "-A"},
+		{"commit", "-q", "-m", "initial"},
+	} {
+		if _, err := runGit("", args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestGitStageCommit(t *testing.T) {
+	t.Chdir(t.TempDir())
+	initGitRepo(t, map[string]string{
+		".gitignore":  "gen/\n",
+		"go.mod":      "module example.com/app\n",
+		"old/a.go":    "package old\n\nfunc A() {}\n",
+		"old/b.go":    "package old\n\n// B is kept as is\nfunc B() {}\n",
+		"cmd/main.go": "package main\n\nimport \"example.com/app/old\"\n",
+	})
+	writeTree(t, map[string]string{"untracked.txt": "left alone\n"})
+	if err := gitCheckClean(""); err != nil {
+		t.Fatalf("gitCheckClean() error = %v", err)
+	}
+
+	plan := &renamePlan{moves: []dirMove{{from: "old", to: "new"}}}
+	plan.files = []fileChange{
+		{path: "old/a.go", newPath: "new/a.go", mode: 0644, before: []byte("package old\n\nfunc A() {}\n"), after: []byte("package new\n\nfunc A() {}\n")},
+		{path: "cmd/main.go", newPath: "cmd/main.go", mode: 0644, before: []byte("package main\n\nimport \"example.com/app/old\"\n"), after: []byte("package main\n\nimport \"example.com/app/new\"\n")},
+	}
+	message := commitMessage("Rename package example.com/app/old to example.com/app/new", []ImportRename{{Old: "example.com/app/old", New: "example.com/app/new"}})
+	if err := runPlan(plan, runOptions{git: &gitOptions{commit: true, message: message}}); err != nil {
+		t.Fatalf("runPlan() error = %v", err)
+	}
+
+	if err := gitCheckClean(""); err != nil {
+		t.Errorf("tree should be clean after the commit: %v", err)
+	}
+	body, err := runGit("", "log", "-1", "--format=%B")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := strings.TrimSpace(message); body != want {
+		t.Errorf("commit message = %q, want %q", body, want)
+	}
+	changes, err := runGit("", "show", "--name-status", "-M", "--format=")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"M\tcmd/main.go", "R100\told/b.go\tnew/b.go"} {
+		if !strings.Contains(changes, want) {
+			t.Errorf("commit changes = %q, want %q", changes, want)
+		}
+	}
+	if status, _ := runGit("", "status", "--porcelain"); status != "?? untracked.txt" {
+		t.Errorf("status = %q, untracked files should not be staged", status)
+	}
+}
+
+func TestGitCommitFailureRollsBack(t *testing.T) {
+	original := map[string]string{
+		"go.mod":      "module example.com/app\n",
+		"a/a.go":      "package a\n",
+		"b/b.go":      "package b\n",
+		"cmd/main.go": "package main\n\nimport _ \"example.com/app/a\"\n",
+	}
+	tests := []struct {
+		name string
+		opts Options
+	}{
+		{"swap", Options{Moves: []Move{{From: "a", To: "b"}, {From: "b", To: "a"}}}},
+		{"replaced target", Options{From: "a", To: "b", Force: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Chdir(t.TempDir())
+			initGitRepo(t, original)
+			// A rejecting hook makes the commit fail after the rename is applied and journaled
+			if err := os.WriteFile(".git/hooks/pre-commit", []byte("#!/bin/sh\nexit 1\n"), 0755); err != nil {
+				t.Fatal(err)
+			}
+
+			tt.opts.Commit = true
+			changes, err := Plan(t.Context(), tt.opts)
+			if err != nil {
+				t.Fatalf("Plan() error = %v", err)
+			}
+			if err := changes.Err(); err != nil {
+				t.Fatalf("Err() = %v", err)
+			}
+			err = Apply(changes)
+			if err == nil || strings.Contains(err.Error(), "rollback incomplete") {
+				t.Fatalf("Apply() error = %v, want the commit failure rolled back", err)
+			}
+			if _, err := os.Stat(StateDir); !os.IsNotExist(err) {
+				t.Errorf("%s left behind after the rollback: %v", StateDir, err)
+			}
+			if err := os.RemoveAll(".git"); err != nil {
+				t.Fatal(err)
+			}
+			assertTree(t, original)
+		})
+	}
+}
+
+func TestGitCheckCleanRefusesChanges(t *testing.T) {
+	t.Chdir(t.TempDir())
+	initGitRepo(t, map[string]string{"go.mod": "module example.com/app\n", "old/a.go": "package old\n"})
+	writeTree(t, map[string]string{"go.mod": "module example.com/edited\n"})
+
+	if err := gitCheckClean(""); err == nil || !strings.Contains(err.Error(), "go.mod") {
+		t.Errorf("gitCheckClean() error = %v, want the modified go.mod listed", err)
+	}
+
+	// Plan only reads the files, the check runs when the rename is applied
+	changes, err := Plan(t.Context(), Options{From: "old", To: "new", Commit: true})
+	if err != nil {
+		t.Fatalf("Plan() error = %v", err)
+	}
+	if err := Apply(changes); err == nil || !strings.Contains(err.Error(), "clean working tree") {
+		t.Errorf("Apply() error = %v, want the dirty tree refused", err)
+	}
+	if _, err := os.Stat("old/a.go"); err != nil {
+		t.Errorf("Apply() moved the package: %v", err)
+	}
+}
+
+func TestCheckDirty(t *testing.T) {
+	t.Chdir(t.TempDir())
+	initGitRepo(t, map[string]string{
+		"go.mod":      "module example.com/app\n",
+		"old/a.go":    "package old\n",
+		"old/b.go":    "package old\n",
+		"cmd/main.go": "package main\n",
+		"other.go":    "package main\n",
+	})
+	plan := &renamePlan{
+		moves: []dirMove{{from: "old", to: "new"}},
+		files: []fileChange{{path: "cmd/main.go", newPath: "cmd/main.go"}},
+	}
+
+	if err := checkDirty(plan); err != nil {
+		t.Fatalf("checkDirty() on a clean tree error = %v", err)
+	}
+
+	writeTree(t, map[string]string{
+		"old/b.go":    "package old\n\nfunc Edited() {}\n",
+		"old/new.go":  "package old\n",
+		"cmd/main.go": "package main\n\n// edited\n",
+		"other.go":    "package main\n\n// edited, but not touched by the rename\n",
+	})
+	if _, err := runGit("", "add", "cmd/main.go"); err != nil {
+		t.Fatal(err)
+	}
+
+	err := checkDirty(plan)
+	if err == nil {
+		t.Fatal("checkDirty() expected error")
+	}
+	for _, want := range []string{"cmd/main.go", "old/b.go", "old/new.go"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("checkDirty() error = %v, want %s listed", err, want)
+		}
+	}
+	if strings.Contains(err.Error(), "other.go") {
+		t.Errorf("checkDirty() error = %v, other.go is not touched by the rename", err)
+	}
+}
@@ -0,0 +1,227 @@
+package rename
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/mod/modfile"
+)
+
+// readModuleFromGoMod reads the module path from the go.mod file in root
+func readModuleFromGoMod(root string) (string, error) {
+	data, err := os.ReadFile(filepath.Join(root, "go.mod"))
+	if err != nil {
+		return "", fmt.Errorf("failed to read go.mod: %v", err)
+	}
+
+	if modulePath := modfile.ModulePath(data); modulePath != "" {
+		return modulePath, nil
+	}
+	return "", fmt.Errorf("module declaration not found in go.mod")
+}
+
+// updateGoMod returns the go.mod content with every reference to oldModule or a module nested
+// under it renamed to newModule: the module line and the require, replace and exclude directives
+// (retract directives only list versions). Only the renamed paths change, the layout, spacing
+// and comments are kept byte-for-byte.
+// References to the keep modules, nested modules that keep their own path, are left alone
+func updateGoMod(path string, data []byte, oldModule, newModule string, keep ...string) ([]byte, error) {
+	f, err := modfile.Parse(path, data, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var edits []edit
+	if f.Module != nil {
+		edits = renameModuleTokens(data, f.Module.Syntax, oldModule, newModule, keep)
+	}
+	for _, r := range f.Require {
+		edits = append(edits, renameModuleTokens(data, r.Syntax, oldModule, newModule, keep)...)
+	}
+	for _, r := range f.Replace {
+		edits = append(edits, renameModuleTokens(data, r.Syntax, oldModule, newModule, keep)...)
+	}
+	for _, e := range f.Exclude {
+		edits = append(edits, renameModuleTokens(data, e.Syntax, oldModule, newModule, keep)...)
+	}
+	return applyEdits(data, edits), nil
+}
+
+// workspace is the go.work enclosing the module root
+// path and members (the use directories) start with the module root, like the other paths of a plan
+type workspace struct {
+	path    string
+	data    []byte
+	members []string
+}
+
+// findWorkspace locates the go.work enclosing root, honoring GOWORK like the go command
+// It returns nil if there is none or workspaces are turned off
+func findWorkspace(root string) (*workspace, error) {
+	base, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+
+	path := os.Getenv("GOWORK")
+	switch path {
+	case "off":
+		return nil, nil
+	case "":
+		for dir := base; ; dir = filepath.Dir(dir) {
+			if _, err := os.Stat(filepath.Join(dir, "go.work")); err == nil {
+				path = filepath.Join(dir, "go.work")
+				break
+			}
+			if filepath.Dir(dir) == dir {
+				return nil, nil
+			}
+		}
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(base, path)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read go.work: %v", err)
+	}
+	wf, err := modfile.ParseWork(path, data, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	ws := &workspace{data: data}
+	if ws.path, err = filepath.Rel(base, path); err != nil {
+		return nil, err
+	}
+	ws.path = filepath.Join(root, ws.path)
+	for _, use := range wf.Use {
+		dir := use.Path
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(filepath.Dir(path), dir)
+		}
+		member, err := filepath.Rel(base, dir)
+		if err != nil {
+			return nil, err
+		}
+		ws.members = append(ws.members, filepath.Join(root, member))
+	}
+	return ws, nil
+}
+
+// renameModuleTokens returns the edits of data renaming the tokens of a go.mod or go.work line
+// that name oldModule or a module nested under it. A quoted token stays quoted.
+// Filesystem paths on the right of a replace never match a module path
+func renameModuleTokens(data []byte, line *modfile.Line, oldModule, newModule string, keep []string) []edit {
+	if line == nil {
+		return nil
+	}
+
+	// The tokens appear in order in the text of the line
+	var edits []edit
+	text := string(data[line.Start.Byte:line.End.Byte])
+	offset := line.Start.Byte
+	for _, tok := range line.Token {
+		i := strings.Index(text, tok)
+		if i < 0 {
+			break
+		}
+		start := offset + i
+		text, offset = text[i+len(tok):], start+len(tok)
+
+		path, quoted := tok, false
+		if unquoted, err := strconv.Unquote(tok); err == nil {
+			path, quoted = unquoted, true
+		}
+		newPath, ok := renameModulePath(path, oldModule, newModule, keep)
+		if !ok {
+			continue
+		}
+		if quoted {
+			newPath = strconv.Quote(newPath)
+		} else {
+			newPath = modfile.AutoQuote(newPath)
+		}
+		edits = append(edits, edit{start: start, end: start + len(tok), text: newPath})
+	}
+	return edits
+}
+
+// updateGoWork returns the go.work content with the replace directives that point at oldModule
+// renamed to newModule
+// use directives name directories, which a module rename does not move
+func updateGoWork(path string, data []byte, oldModule, newModule string, keep ...string) ([]byte, error) {
+	wf, err := modfile.ParseWork(path, data, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var edits []edit
+	for _, r := range wf.Replace {
+		edits = append(edits, renameModuleTokens(data, r.Syntax, oldModule, newModule, keep)...)
+	}
+	return applyEdits(data, edits), nil
+}
+
+// goModule is a module nested below the working directory
+type goModule struct {
+	dir  string
+	path string
+}
+
+// isModuleRoot reports whether dir contains a go.mod
+func isModuleRoot(dir string) bool {
+	info, err := os.Stat(filepath.Join(dir, "go.mod"))
+	return err == nil && !info.IsDir()
+}
+
+// findNestedModules returns every module below root, including modules nested in other nested modules
+// root itself and directories left out by filter are not reported
+func findNestedModules(root string, filter *walkFilter) ([]goModule, error) {
+	if filter == nil {
+		filter = &walkFilter{}
+	}
+	filter.init()
+	var modules []goModule
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() || path == root {
+			return nil
+		}
+		if filter.skipDir(path) {
+			return filepath.SkipDir
+		}
+		if !isModuleRoot(path) {
+			return nil
+		}
+		data, err := os.ReadFile(filepath.Join(path, "go.mod"))
+		if err != nil {
+			return err
+		}
+		modules = append(modules, goModule{dir: path, path: modfile.ModulePath(data)})
+		return nil
+	})
+	return modules, err
+}
+
+// moduleOf returns the innermost nested module containing dir, or nil if dir belongs to the root module
+func moduleOf(dir string, modules []goModule) *goModule {
+	dir = filepath.Clean(dir)
+	var owner *goModule
+	for i, m := range modules {
+		if dir != m.dir && !strings.HasPrefix(dir, m.dir+string(filepath.Separator)) {
+			continue
+		}
+		if owner == nil || len(m.dir) > len(owner.dir) {
+			owner = &modules[i]
+		}
+	}
+	return owner
+}
@@ -0,0 +1,245 @@
+package rename
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestUpdateGoMod(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			name: "module line and directives",
+			input: `// Main module
+module github.com/pillar/chrop // renamed soon
+
+go 1.22
+
+require (
+	github.com/pillar/chrop/tools v0.1.0 // nested module
+	github.com/pillar/chropx v1.0.0
+)
+
+require "github.com/pillar/chrop/api" v0.2.0
+
+replace (
+	github.com/pillar/chrop/tools => ./tools
+	github.com/other/lib v1.0.0 => github.com/pillar/chrop/forks/lib v1.0.1
+)
+
+exclude github.com/pillar/chrop/api v0.1.0
+
+retract v0.0.1 // published by mistake
+`,
+			expected: `// Main module
+module github.com/pillar/doaddon // renamed soon
+
+go 1.22
+
+require (
+	github.com/pillar/doaddon/tools v0.1.0 // nested module
+	github.com/pillar/chropx v1.0.0
+)
+
+require "github.com/pillar/doaddon/api" v0.2.0
+
+replace (
+	github.com/pillar/doaddon/tools => ./tools
+	github.com/other/lib v1.0.0 => github.com/pillar/doaddon/forks/lib v1.0.1
+)
+
+exclude github.com/pillar/doaddon/api v0.1.0
+
+retract v0.0.1 // published by mistake
+`,
+		},
+		{
+			name: "dependency of a workspace member",
+			input: `module github.com/pillar/app
+
+go 1.22
+
+require github.com/pillar/chrop v0.0.0
+
+replace github.com/pillar/chrop => ../chrop
+`,
+			expected: `module github.com/pillar/app
+
+go 1.22
+
+require github.com/pillar/doaddon v0.0.0
+
+replace github.com/pillar/doaddon => ../chrop
+`,
+		},
+		{
+			name:     "layout is kept",
+			input:    "module   github.com/pillar/chrop\n\n\n\nrequire (\n\tgithub.com/pillar/chrop/tools    v0.1.0 //  nested\n\n\tgithub.com/other/lib v1.0.0\n)\n",
+			expected: "module   github.com/pillar/doaddon\n\n\n\nrequire (\n\tgithub.com/pillar/doaddon/tools    v0.1.0 //  nested\n\n\tgithub.com/other/lib v1.0.0\n)\n",
+		},
+		{
+			name:     "unrelated go.mod is left byte-identical",
+			input:    "module   github.com/pillar/app\n\nrequire github.com/pillar/chropx v1.0.0\n",
+			expected: "module   github.com/pillar/app\n\nrequire github.com/pillar/chropx v1.0.0\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := updateGoMod("go.mod", []byte(tt.input), "github.com/pillar/chrop", "github.com/pillar/doaddon")
+			if err != nil {
+				t.Fatalf("updateGoMod() error = %v", err)
+			}
+			if string(result) != tt.expected {
+				t.Errorf("updateGoMod() = \n%s\n, want \n%s", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestPlanModuleRenameWorkspace(t *testing.T) {
+	t.Chdir(t.TempDir())
+	t.Setenv("GOWORK", "")
+	writeTree(t, map[string]string{
+		"go.work":           "go 1.22\n\nuse (\n\t./chrop\n\t./app\n)\n\nreplace github.com/pillar/chrop v0.0.0 => ./chrop\n",
+		"chrop/go.mod":      "module github.com/pillar/chrop\n\ngo 1.22\n",
+		"chrop/di/di.go":    "package di\n",
+		"chrop/main.go":     "package main\n\nimport \"github.com/pillar/chrop/di\"\n",
+		"app/go.mod":        "module github.com/pillar/app\n\ngo 1.22\n\nrequire github.com/pillar/chrop v0.0.0\n",
+		"app/main.go":       "package main\n\nimport \"github.com/pillar/chrop/di\"\n",
+		"app/other/main.go": "package other\n\nimport \"fmt\"\n",
+	})
+	t.Chdir("chrop")
+
+	plan := &renamePlan{}
+	if _, err := planModuleRename(t.Context(), plan, Options{Module: "github.com/pillar/doaddon"}, nil, "github.com/pillar/chrop", nil); err != nil {
+		t.Fatalf("planModuleRename() error = %v", err)
+	}
+
+	expected := map[string]string{
+		"main.go":        "package main\n\nimport \"github.com/pillar/doaddon/di\"\n",
+		"go.mod":         "module github.com/pillar/doaddon\n\ngo 1.22\n",
+		"../app/main.go": "package main\n\nimport \"github.com/pillar/doaddon/di\"\n",
+		"../app/go.mod":  "module github.com/pillar/app\n\ngo 1.22\n\nrequire github.com/pillar/doaddon v0.0.0\n",
+		"../go.work":     "go 1.22\n\nuse (\n\t./chrop\n\t./app\n)\n\nreplace github.com/pillar/doaddon v0.0.0 => ./chrop\n",
+	}
+	if len(plan.files) != len(expected) {
+		t.Errorf("planned %d files, want %d", len(plan.files), len(expected))
+	}
+	for _, f := range plan.files {
+		want, ok := expected[f.path]
+		if !ok {
+			t.Errorf("unexpected change to %s", f.path)
+			continue
+		}
+		if string(f.after) != want {
+			t.Errorf("%s = \n%s\n, want \n%s", f.path, f.after, want)
+		}
+	}
+}
+
+func TestPlanModuleRenameNestedModules(t *testing.T) {
+	t.Chdir(t.TempDir())
+	t.Setenv("GOWORK", "off")
+	writeTree(t, map[string]string{
+		"go.mod":            "module github.com/pillar/chrop\n\ngo 1.22\n\nrequire github.com/pillar/chrop/tools v0.1.0\n",
+		"main.go":           "package main\n\nimport (\n\t\"github.com/pillar/chrop/di\"\n\t\"github.com/pillar/chrop/tools/gen\"\n)\n",
+		"di/di.go":          "package di\n",
+		"tools/go.mod":      "module github.com/pillar/chrop/tools\n\ngo 1.22\n\nrequire github.com/pillar/chrop v0.0.0\n",
+		"tools/gen/gen.go":  "package gen\n\nimport \"github.com/pillar/chrop/di\"\n",
+		"tools/main.go":     "package main\n\nimport \"github.com/pillar/chrop/tools/gen\"\n",
+		"example/go.mod":    "module example.com/demo\n\ngo 1.22\n",
+		"example/demo/a.go": "package demo\n\nimport \"github.com/pillar/chrop/di\"\n",
+	})
+
+	tests := []struct {
+		name          string
+		includeNested bool
+		expected      map[string]string
+	}{
+		{
+			name: "nested modules are not crossed by default",
+			expected: map[string]string{
+				"main.go": "package main\n\nimport (\n\t\"github.com/pillar/chrop/tools/gen\"\n\t\"github.com/pillar/doaddon/di\"\n)\n",
+				"go.mod":  "module github.com/pillar/doaddon\n\ngo 1.22\n\nrequire github.com/pillar/chrop/tools v0.1.0\n",
+			},
+		},
+		{
+			name:          "included nested modules are matched against their own path",
+			includeNested: true,
+			expected: map[string]string{
+				"main.go":                                "package main\n\nimport (\n\t\"github.com/pillar/doaddon/di\"\n\t\"github.com/pillar/doaddon/tools/gen\"\n)\n",
+				"go.mod":                                 "module github.com/pillar/doaddon\n\ngo 1.22\n\nrequire github.com/pillar/doaddon/tools v0.1.0\n",
+				filepath.Join("tools", "gen", "gen.go"):  "package gen\n\nimport \"github.com/pillar/doaddon/di\"\n",
+				filepath.Join("tools", "main.go"):        "package main\n\nimport \"github.com/pillar/doaddon/tools/gen\"\n",
+				filepath.Join("tools", "go.mod"):         "module github.com/pillar/doaddon/tools\n\ngo 1.22\n\nrequire github.com/pillar/doaddon v0.0.0\n",
+				filepath.Join("example", "demo", "a.go"): "package demo\n\nimport \"github.com/pillar/doaddon/di\"\n",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan := &renamePlan{}
+			if _, err := planModuleRename(t.Context(), plan, Options{Module: "github.com/pillar/doaddon", NestedModules: tt.includeNested}, nil, "github.com/pillar/chrop", nil); err != nil {
+				t.Fatalf("planModuleRename() error = %v", err)
+			}
+			if len(plan.files) != len(tt.expected) {
+				t.Errorf("planned %d files, want %d", len(plan.files), len(tt.expected))
+			}
+			for _, f := range plan.files {
+				want, ok := tt.expected[f.path]
+				if !ok {
+					t.Errorf("unexpected change to %s", f.path)
+					continue
+				}
+				if string(f.after) != want {
+					t.Errorf("%s = \n%s\n, want \n%s", f.path, f.after, want)
+				}
+			}
+		})
+	}
+}
+
+func TestModuleOf(t *testing.T) {
+	t.Chdir(t.TempDir())
+	writeTree(t, map[string]string{
+		"go.mod":                      "module example.com/app\n",
+		"tools/go.mod":                "module example.com/tools\n",
+		"tools/lint/go.mod":           "module example.com/tools/lint\n",
+		"tools/lint/rules/a.go":       "package rules\n",
+		"toolsx/a.go":                 "package toolsx\n",
+		"vendor/example.com/x/go.mod": "module example.com/x\n",
+	})
+
+	modules, err := findNestedModules(".", nil)
+	if err != nil {
+		t.Fatalf("findNestedModules() error = %v", err)
+	}
+	if len(modules) != 2 {
+		t.Fatalf("findNestedModules() = %v, want tools and tools/lint", modules)
+	}
+
+	tests := []struct {
+		dir  string
+		want string
+	}{
+		{dir: "internal/a", want: ""},
+		{dir: "toolsx", want: ""},
+		{dir: "tools", want: "example.com/tools"},
+		{dir: "tools/gen", want: "example.com/tools"},
+		{dir: "tools/lint/rules", want: "example.com/tools/lint"},
+	}
+	for _, tt := range tests {
+		got := ""
+		if m := moduleOf(filepath.FromSlash(tt.dir), modules); m != nil {
+			got = m.path
+		}
+		if got != tt.want {
+			t.Errorf("moduleOf(%s) = %q, want %q", tt.dir, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,258 @@
+package rename
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ignoreRule is a single .gitignore pattern or --exclude/--include glob
+// Patterns without a slash match the base name at any depth, the others are anchored at base
+type ignoreRule struct {
+	base     string // absolute directory the pattern is relative to
+	pattern  string
+	negate   bool
+	dirOnly  bool
+	anchored bool
+}
+
+// newIgnoreRule parses a gitignore-style pattern relative to base
+func newIgnoreRule(base, pattern string) ignoreRule {
+	r := ignoreRule{base: base}
+	if strings.HasPrefix(pattern, "!") {
+		r.negate = true
+		pattern = pattern[1:]
+	} else if strings.HasPrefix(pattern, `\`) {
+		pattern = pattern[1:]
+	}
+	if strings.HasSuffix(pattern, "/") {
+		r.dirOnly = true
+		pattern = strings.TrimRight(pattern, "/")
+	}
+	pattern = strings.TrimPrefix(pattern, "./")
+	r.anchored = strings.Contains(pattern, "/")
+	r.pattern = strings.TrimPrefix(pattern, "/")
+	return r
+}
+
+// parseGitignore returns the rules of a .gitignore file in dir, comments and blank lines are skipped
+func parseGitignore(dir string, data []byte) []ignoreRule {
+	var rules []ignoreRule
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimRight(line, " \r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rules = append(rules, newIgnoreRule(dir, line))
+	}
+	return rules
+}
+
+// match reports whether the rule matches the absolute path abs
+func (r ignoreRule) match(abs string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+	rel, err := filepath.Rel(r.base, abs)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return false
+	}
+	rel = filepath.ToSlash(rel)
+	if !r.anchored {
+		rel = path.Base(rel)
+	}
+	return matchGlob(r.pattern, rel)
+}
+
+// mayMatchUnder reports whether the rule could match a path below the absolute directory abs
+func (r ignoreRule) mayMatchUnder(abs string) bool {
+	if !r.anchored {
+		return true
+	}
+	rel, err := filepath.Rel(r.base, abs)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return false
+	}
+	if rel == "." {
+		return true
+	}
+	pat := strings.Split(r.pattern, "/")
+	for _, seg := range strings.Split(filepath.ToSlash(rel), "/") {
+		if len(pat) == 0 {
+			return false
+		}
+		if pat[0] == "**" {
+			return true
+		}
+		if ok, _ := path.Match(pat[0], seg); !ok {
+			return false
+		}
+		pat = pat[1:]
+	}
+	return len(pat) > 0
+}
+
+// matchGlob matches a slash separated name against pattern, where ** matches any number of
+// path elements and the other elements follow path.Match
+func matchGlob(pattern, name string) bool {
+	return matchElems(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchElems(pat, name []string) bool {
+	for len(pat) > 0 {
+		if pat[0] == "**" {
+			// A trailing ** matches everything inside, but not the directory itself
+			if len(pat) == 1 {
+				return len(name) > 0
+			}
+			for i := 0; i <= len(name); i++ {
+				if matchElems(pat[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pat[0], name[0]); !ok {
+			return false
+		}
+		pat, name = pat[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// walkFilter decides which directories and files walkGoFiles leaves out
+// On top of the built-in exclusions (vendor, node_modules, .git and the state directory) it skips
+// testdata directories, paths ignored by .gitignore files and the --exclude globs.
+// --include globs win over everything but the built-in exclusions.
+type walkFilter struct {
+	skip     []string
+	excludes []ignoreRule
+	includes []ignoreRule
+	ignore   map[string][]ignoreRule // .gitignore rules in effect for the entries of an absolute directory
+	excluded map[string]bool         // walked directories that are excluded but may contain included paths
+	included map[string]bool         // walked directories matched by an include glob
+}
+
+// newWalkFilter returns a filter for the --exclude and --include globs, relative to root
+func newWalkFilter(root string, excludes, includes []string) (*walkFilter, error) {
+	base, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	f := &walkFilter{}
+	f.init()
+	for _, list := range []struct {
+		globs []string
+		rules *[]ignoreRule
+	}{{excludes, &f.excludes}, {includes, &f.includes}} {
+		for _, glob := range list.globs {
+			if _, err := path.Match(glob, ""); err != nil {
+				return nil, fmt.Errorf("invalid glob %q: %v", glob, err)
+			}
+			*list.rules = append(*list.rules, newIgnoreRule(base, filepath.ToSlash(glob)))
+		}
+	}
+	return f, nil
+}
+
+// skipDir reports whether the walk should not enter dir
+func (f *walkFilter) skipDir(dir string) bool {
+	switch filepath.Base(dir) {
+	case StateDir, "vendor", "node_modules", ".git":
+		return true
+	}
+	for _, s := range f.skip {
+		if dir == filepath.Clean(s) {
+			return true
+		}
+	}
+
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return false
+	}
+	parent := filepath.Dir(dir)
+	if f.included[parent] || f.matches(f.includes, abs, true) {
+		f.included[dir] = true
+		return false
+	}
+	if f.excluded[parent] || filepath.Base(dir) == "testdata" || f.matches(f.excludes, abs, true) || f.ignored(abs, true) {
+		// Keep walking if an include glob may pick something up further down
+		for _, r := range f.includes {
+			if r.mayMatchUnder(abs) {
+				f.excluded[dir] = true
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// skipFile reports whether the walk should leave the file at path alone
+func (f *walkFilter) skipFile(file string) bool {
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return false
+	}
+	parent := filepath.Dir(file)
+	if f.included[parent] || f.matches(f.includes, abs, false) {
+		return false
+	}
+	return f.excluded[parent] || f.matches(f.excludes, abs, false) || f.ignored(abs, false)
+}
+
+// init allocates the state the filter builds up during a walk
+func (f *walkFilter) init() {
+	if f.ignore == nil {
+		f.ignore = make(map[string][]ignoreRule)
+		f.excluded = make(map[string]bool)
+		f.included = make(map[string]bool)
+	}
+}
+
+// matches reports whether any of rules matches the absolute path abs
+func (f *walkFilter) matches(rules []ignoreRule, abs string, isDir bool) bool {
+	for _, r := range rules {
+		if r.match(abs, isDir) {
+			return true
+		}
+	}
+	return false
+}
+
+// ignored reports whether the .gitignore files of the repository ignore the absolute path abs
+// Like git, the last matching pattern wins and deeper .gitignore files take precedence
+func (f *walkFilter) ignored(abs string, isDir bool) bool {
+	ignored := false
+	for _, r := range f.gitignoreRules(filepath.Dir(abs)) {
+		if r.match(abs, isDir) {
+			ignored = !r.negate
+		}
+	}
+	return ignored
+}
+
+// gitignoreRules returns the .gitignore rules in effect for the entries of the absolute directory dir,
+// from the repository root (the first directory holding .git) down to dir
+func (f *walkFilter) gitignoreRules(dir string) []ignoreRule {
+	if rules, ok := f.ignore[dir]; ok {
+		return rules
+	}
+
+	var rules []ignoreRule
+	_, err := os.Stat(filepath.Join(dir, ".git"))
+	if parent := filepath.Dir(dir); err != nil && parent != dir {
+		rules = append(rules, f.gitignoreRules(parent)...)
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, ".gitignore")); err == nil {
+		rules = append(rules, parseGitignore(dir, data)...)
+	}
+	f.ignore[dir] = rules
+	return rules
+}
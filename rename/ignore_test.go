@@ -0,0 +1,96 @@
+package rename
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		want    bool
+	}{
+		{pattern: "*.go", name: "a.go", want: true},
+		{pattern: "*.go", name: "a/b.go", want: false},
+		{pattern: "gen/*.go", name: "gen/a.go", want: true},
+		{pattern: "gen/*.go", name: "gen/sub/a.go", want: false},
+		{pattern: "gen/**", name: "gen/sub/a.go", want: true},
+		{pattern: "gen/**", name: "gen", want: false},
+		{pattern: "**/mocks", name: "mocks", want: true},
+		{pattern: "**/mocks", name: "internal/x/mocks", want: true},
+		{pattern: "a/**/b", name: "a/b", want: true},
+		{pattern: "a/**/b", name: "a/x/y/b", want: true},
+		{pattern: "a/**/b", name: "a/x/y/c", want: false},
+	}
+
+	for _, tt := range tests {
+		if got := matchGlob(tt.pattern, tt.name); got != tt.want {
+			t.Errorf("matchGlob(%q, %q) = %v, want %v", tt.pattern, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestWalkGoFilesFilter(t *testing.T) {
+	t.Chdir(t.TempDir())
+	if err := os.Mkdir(".git", 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeTree(t, map[string]string{
+		".gitignore":                "# build output\nbuild/\n*.gen.go\n!keep.gen.go\n/root_only.go\n",
+		"main.go":                   "package main\n",
+		"root_only.go":              "package main\n",
+		"api/api.gen.go":            "package api\n",
+		"api/keep.gen.go":           "package api\n",
+		"api/root_only.go":          "package api\n",
+		"api/.gitignore":            "local.go\n",
+		"api/local.go":              "package api\n",
+		"build/out.go":              "package build\n",
+		"fixtures/a/a.go":           "package a\n",
+		"internal/x/x.go":           "package x\n",
+		"internal/x/testdata/t.go":  "package testdata\n",
+		"testdata/golden/g.go":      "package golden\n",
+		"testdata/input/i.go":       "package input\n",
+		"node_modules/pkg/pkg.go":   "package pkg\n",
+		"vendor/example.com/v/v.go": "package v\n",
+		StateDir + "/journal/j.go":  "package journal\n",
+		"replaced/r.go":             "package replaced\n",
+	})
+
+	filter, err := newWalkFilter(".", []string{"fixtures/**"}, []string{"testdata/golden/*.go"})
+	if err != nil {
+		t.Fatalf("newWalkFilter() error = %v", err)
+	}
+	filter.skip = []string{"replaced"}
+
+	var walked []string
+	err = walkGoFiles(".", filter, func(path string, info fs.FileInfo) error {
+		walked = append(walked, filepath.ToSlash(path))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("walkGoFiles() error = %v", err)
+	}
+	sort.Strings(walked)
+
+	expected := []string{
+		"api/keep.gen.go",
+		"api/root_only.go",
+		"internal/x/x.go",
+		"main.go",
+		"testdata/golden/g.go",
+	}
+	if !reflect.DeepEqual(walked, expected) {
+		t.Errorf("walkGoFiles() = %v, want %v", walked, expected)
+	}
+}
+
+func TestNewWalkFilterInvalidGlob(t *testing.T) {
+	if _, err := newWalkFilter(".", []string{"gen/["}, nil); err == nil {
+		t.Error("newWalkFilter() expected error for a malformed glob")
+	}
+}
@@ -0,0 +1,120 @@
+package rename
+
+import (
+	"go/parser"
+	"go/token"
+	"strconv"
+	"strings"
+)
+
+// importRewriteFunc returns the wanted name and path for an import spec.
+// name is "" when the import has no explicit name; returning the inputs unchanged leaves the spec alone.
+type importRewriteFunc func(name, path string) (newName, newPath string)
+
+// rewriteImportSpecs parses the import declarations of src and rewrites the name and path
+// of every ImportSpec according to fn.
+// Only the changed ImportSpec nodes are touched, the rest of the file is kept byte-for-byte.
+func rewriteImportSpecs(src []byte, fn importRewriteFunc) ([]byte, error) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "", src, parser.ImportsOnly|parser.ParseComments)
+	if err != nil {
+		return src, err
+	}
+
+	offset := func(pos token.Pos) int {
+		return fset.Position(pos).Offset
+	}
+
+	var edits []edit
+	for _, spec := range file.Imports {
+		oldPath, err := strconv.Unquote(spec.Path.Value)
+		if err != nil {
+			continue
+		}
+		oldName := ""
+		if spec.Name != nil {
+			oldName = spec.Name.Name
+		}
+
+		newName, newPath := fn(oldName, oldPath)
+
+		// Name edits go first so an inserted alias lands before a replaced path at the same offset
+		if newName != oldName {
+			switch {
+			case spec.Name == nil:
+				edits = append(edits, edit{start: offset(spec.Path.Pos()), end: offset(spec.Path.Pos()), text: newName + " "})
+			case newName == "":
+				edits = append(edits, edit{start: offset(spec.Name.Pos()), end: offset(spec.Path.Pos())})
+			default:
+				edits = append(edits, edit{start: offset(spec.Name.Pos()), end: offset(spec.Name.End()), text: newName})
+			}
+		}
+		if newPath != oldPath {
+			edits = append(edits, edit{start: offset(spec.Path.Pos()), end: offset(spec.Path.End()), text: quoteImportPath(spec.Path.Value, newPath)})
+		}
+	}
+
+	return applyEdits(src, edits), nil
+}
+
+// quoteImportPath quotes path the same way as the original literal (interpreted or raw string)
+func quoteImportPath(original, path string) string {
+	if strings.HasPrefix(original, "`") {
+		return "`" + path + "`"
+	}
+	return strconv.Quote(path)
+}
+
+// replaceImports replaces imports of oldImport with newImport
+// oldName and newName are the declared package names before and after the rename
+// An import without a name keeps resolving to oldName through an alias when the names differ,
+// imports with an explicit name (including blank and dot imports) already resolve and keep it
+// Imports of packages nested under oldImport are moved along and keep their names
+// A file that cannot be parsed is returned unchanged along with the error
+func replaceImports(src, oldImport, newImport, oldName, newName string) (string, error) {
+	updated, err := rewriteImportSpecs([]byte(src), func(name, path string) (string, string) {
+		if strings.HasPrefix(path, oldImport+"/") {
+			return name, newImport + strings.TrimPrefix(path, oldImport)
+		}
+		if path != oldImport {
+			return name, path
+		}
+		if name == "" && oldName != newName {
+			return oldName, newImport
+		}
+		return name, newImport
+	})
+	if err != nil {
+		return src, err
+	}
+	return string(updated), nil
+}
+
+// renameModulePath returns path moved from oldModule to newModule and whether it matched
+// Paths inside one of the keep modules belong to a nested module that keeps its own path
+func renameModulePath(path, oldModule, newModule string, keep []string) (string, bool) {
+	if path != oldModule && !strings.HasPrefix(path, oldModule+"/") {
+		return path, false
+	}
+	for _, k := range keep {
+		if path == k || strings.HasPrefix(path, k+"/") {
+			return path, false
+		}
+	}
+	return newModule + strings.TrimPrefix(path, oldModule), true
+}
+
+// replaceModuleImports replaces imports of oldModule and all packages under it with newModule
+// Modules that merely share the prefix (oldModule + "x") and the packages of the keep modules are left alone
+// Preserves existing aliases, but does not add aliases if none exist
+// A file that cannot be parsed is returned unchanged along with the error
+func replaceModuleImports(src, oldModule, newModule string, keep ...string) (string, error) {
+	updated, err := rewriteImportSpecs([]byte(src), func(name, path string) (string, string) {
+		newPath, _ := renameModulePath(path, oldModule, newModule, keep)
+		return name, newPath
+	})
+	if err != nil {
+		return src, err
+	}
+	return string(updated), nil
+}
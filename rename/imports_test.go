@@ -1,4 +1,4 @@
-package main
+package rename
 
 import (
 	"strings"
@@ -8,7 +8,6 @@ import (
 func TestReplaceImports(t *testing.T) {
 	oldImport := "github.com/pillar/chrop/internal/server/di"
 	newImport := "github.com/pillar/chrop/internal/server/difish"
-	alias := "di"
 
 	tests := []struct {
 		name     string
@@ -31,7 +30,7 @@ func main() {
 }`,
 		},
 		{
-			name: "single line import with alias",
+			name: "single line import with alias - should keep alias",
 			input: `package main
 
 import oldAlias "github.com/pillar/chrop/internal/server/di"
@@ -40,7 +39,7 @@ func main() {
 }`,
 			expected: `package main
 
-import di "github.com/pillar/chrop/internal/server/difish"
+import oldAlias "github.com/pillar/chrop/internal/server/difish"
 
 func main() {
 }`,
@@ -67,7 +66,7 @@ func main() {
 }`,
 		},
 		{
-			name: "import block with alias",
+			name: "import block with alias - should keep alias",
 			input: `package main
 
 import (
@@ -80,7 +79,7 @@ func main() {
 			expected: `package main
 
 import (
-	di "github.com/pillar/chrop/internal/server/difish"
+	oldAlias "github.com/pillar/chrop/internal/server/difish"
 	"other/package"
 )
 
@@ -196,7 +195,7 @@ func main() {
 			expected: `package main
 
 import (
-	di "github.com/pillar/chrop/internal/server/difish"
+	oldAlias "github.com/pillar/chrop/internal/server/difish"
 	di "github.com/pillar/chrop/internal/server/difish"
 )
 
@@ -236,8 +235,11 @@ func main() {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			// This test case needs alias because di -> difish (last segment changed)
-			result := replaceImports(tt.input, oldImport, newImport, alias, true)
+			// This test case needs alias because the package name changes from di to difish
+			result, err := replaceImports(tt.input, oldImport, newImport, "di", "difish")
+			if err != nil {
+				t.Fatalf("replaceImports() error = %v", err)
+			}
 			if normalize(result) != normalize(tt.expected) {
 				t.Errorf("replaceImports() = \n%v\n, want \n%v", result, tt.expected)
 			}
@@ -245,11 +247,93 @@ func main() {
 	}
 }
 
+func TestReplaceImportsNestedPackages(t *testing.T) {
+	oldImport := "github.com/pillar/chrop/internal/server/di"
+	newImport := "github.com/pillar/chrop/internal/server/difish"
+
+	input := `package main
+
+import (
+	"github.com/pillar/chrop/internal/server/di"
+	"github.com/pillar/chrop/internal/server/di/providers"
+	p "github.com/pillar/chrop/internal/server/di/providers/db"
+	"github.com/pillar/chrop/internal/server/dix"
+)
+`
+	expected := `package main
+
+import (
+	di "github.com/pillar/chrop/internal/server/difish"
+	"github.com/pillar/chrop/internal/server/difish/providers"
+	p "github.com/pillar/chrop/internal/server/difish/providers/db"
+	"github.com/pillar/chrop/internal/server/dix"
+)
+`
+	result, err := replaceImports(input, oldImport, newImport, "di", "difish")
+	if err != nil {
+		t.Fatalf("replaceImports() error = %v", err)
+	}
+	if result != expected {
+		t.Errorf("replaceImports() = \n%v\n, want \n%v", result, expected)
+	}
+}
+
+func TestReplaceImportsDeclaredNames(t *testing.T) {
+	tests := []struct {
+		name      string
+		oldImport string
+		newImport string
+		oldName   string
+		newName   string
+		input     string
+		expected  string
+	}{
+		{
+			name:      "directory name differs from package name",
+			oldImport: "example.com/app/go-yaml",
+			newImport: "example.com/app/third_party/go-yaml",
+			oldName:   "yaml",
+			newName:   "yaml",
+			input:     "package main\n\nimport \"example.com/app/go-yaml\"\n",
+			expected:  "package main\n\nimport \"example.com/app/third_party/go-yaml\"\n",
+		},
+		{
+			name:      "version suffix directory",
+			oldImport: "example.com/app/client/v2",
+			newImport: "example.com/app/sdk/v2",
+			oldName:   "client",
+			newName:   "sdk",
+			input:     "package main\n\nimport \"example.com/app/client/v2\"\n",
+			expected:  "package main\n\nimport client \"example.com/app/sdk/v2\"\n",
+		},
+		{
+			name:      "importer already resolving to the new name gets no alias",
+			oldImport: "example.com/app/di",
+			newImport: "example.com/app/difish",
+			oldName:   "di",
+			newName:   "difish",
+			input:     "package main\n\nimport difish \"example.com/app/di\"\n",
+			expected:  "package main\n\nimport difish \"example.com/app/difish\"\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := replaceImports(tt.input, tt.oldImport, tt.newImport, tt.oldName, tt.newName)
+			if err != nil {
+				t.Fatalf("replaceImports() error = %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("replaceImports() = \n%v\n, want \n%v", result, tt.expected)
+			}
+		})
+	}
+}
+
 func TestReplaceImportsWithoutAlias(t *testing.T) {
-	// Test case where last segment doesn't change, so no alias needed
+	// Test case where the package name doesn't change, so no alias needed
 	oldImport := "github.com/pillar/chrop/internal/server/di"
 	newImport := "github.com/pillar/chrop/internal/app/di"
-	alias := "di"
 
 	tests := []struct {
 		name     string
@@ -317,8 +401,11 @@ func main() {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			// needAlias is false because di -> di (last segment unchanged)
-			result := replaceImports(tt.input, oldImport, newImport, alias, false)
+			// No alias because the package name stays di
+			result, err := replaceImports(tt.input, oldImport, newImport, "di", "di")
+			if err != nil {
+				t.Fatalf("replaceImports() error = %v", err)
+			}
 			if normalize(result) != normalize(tt.expected) {
 				t.Errorf("replaceImports() = \n%v\n, want \n%v", result, tt.expected)
 			}
@@ -427,6 +514,50 @@ import (
 	"other/package"
 )
 
+func main() {
+}`,
+		},
+		{
+			name: "module root import",
+			input: `package main
+
+import (
+	"github.com/pillar/chrop"
+	c "github.com/pillar/chrop"
+)
+
+func main() {
+}`,
+			expected: `package main
+
+import (
+	"github.com/pillar/doaddon"
+	c "github.com/pillar/doaddon"
+)
+
+func main() {
+}`,
+		},
+		{
+			name: "module sharing the prefix is left alone",
+			input: `package main
+
+import (
+	"github.com/pillar/chropx"
+	"github.com/pillar/chropx/internal/server/di"
+	"github.com/pillar/chrop-tools/di"
+)
+
+func main() {
+}`,
+			expected: `package main
+
+import (
+	"github.com/pillar/chropx"
+	"github.com/pillar/chropx/internal/server/di"
+	"github.com/pillar/chrop-tools/di"
+)
+
 func main() {
 }`,
 		},
@@ -434,7 +565,10 @@ func main() {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result := replaceModuleImports(tt.input, oldModule, newModule)
+			result, err := replaceModuleImports(tt.input, oldModule, newModule)
+			if err != nil {
+				t.Fatalf("replaceModuleImports() error = %v", err)
+			}
 			if normalize(result) != normalize(tt.expected) {
 				t.Errorf("replaceModuleImports() = \n%v\n, want \n%v", result, tt.expected)
 			}
@@ -442,6 +576,143 @@ func main() {
 	}
 }
 
+func TestRewriteImportSpecsEdgeCases(t *testing.T) {
+	oldImport := "github.com/pillar/chrop/internal/server/di"
+	newImport := "github.com/pillar/chrop/internal/server/difish"
+
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+		wantErr  bool
+	}{
+		{
+			name: "comment after import block opening",
+			input: `package main
+
+import ( // dependencies
+	"github.com/pillar/chrop/internal/server/di"
+)
+`,
+			expected: `package main
+
+import ( // dependencies
+	di "github.com/pillar/chrop/internal/server/difish"
+)
+`,
+		},
+		{
+			name: "several imports on one line",
+			input: `package main
+
+import ( "fmt"; "github.com/pillar/chrop/internal/server/di" )
+`,
+			expected: `package main
+
+import ( "fmt"; di "github.com/pillar/chrop/internal/server/difish" )
+`,
+		},
+		{
+			name: "multi-line block comment",
+			input: `package main
+
+/*
+import (
+	"github.com/pillar/chrop/internal/server/di"
+)
+*/
+import "fmt"
+`,
+			expected: `package main
+
+/*
+import (
+	"github.com/pillar/chrop/internal/server/di"
+)
+*/
+import "fmt"
+`,
+		},
+		{
+			name:     "raw string literal in code",
+			input:    "package main\n\nimport \"github.com/pillar/chrop/internal/server/di\"\n\nconst tmpl = `\nimport \"github.com/pillar/chrop/internal/server/di\"\n`\n",
+			expected: "package main\n\nimport di \"github.com/pillar/chrop/internal/server/difish\"\n\nconst tmpl = `\nimport \"github.com/pillar/chrop/internal/server/di\"\n`\n",
+		},
+		{
+			name:     "raw string import path",
+			input:    "package main\n\nimport `github.com/pillar/chrop/internal/server/di`\n",
+			expected: "package main\n\nimport di `github.com/pillar/chrop/internal/server/difish`\n",
+		},
+		{
+			name: "blank and dot imports keep their name",
+			input: `package main
+
+import (
+	_ "github.com/pillar/chrop/internal/server/di"
+	. "github.com/pillar/chrop/internal/server/di"
+)
+`,
+			expected: `package main
+
+import (
+	_ "github.com/pillar/chrop/internal/server/difish"
+	. "github.com/pillar/chrop/internal/server/difish"
+)
+`,
+		},
+		{
+			name: "unparsable file is left alone",
+			input: `package main
+
+import (
+	"github.com/pillar/chrop/internal/server/di"
+`,
+			expected: `package main
+
+import (
+	"github.com/pillar/chrop/internal/server/di"
+`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := replaceImports(tt.input, oldImport, newImport, "di", "difish")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("replaceImports() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if result != tt.expected {
+				t.Errorf("replaceImports() = \n%v\n, want \n%v", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestRewriteImportSpecsRemovesName(t *testing.T) {
+	input := `package main
+
+import (
+	di "github.com/pillar/chrop/internal/server/di" // container
+)
+`
+	expected := `package main
+
+import (
+	"github.com/pillar/chrop/internal/server/di" // container
+)
+`
+	result, err := rewriteImportSpecs([]byte(input), func(name, path string) (string, string) {
+		return "", path
+	})
+	if err != nil {
+		t.Fatalf("rewriteImportSpecs() error = %v", err)
+	}
+	if string(result) != expected {
+		t.Errorf("rewriteImportSpecs() = \n%s\n, want \n%s", result, expected)
+	}
+}
+
 // normalize removes trailing whitespace and normalizes line endings
 func normalize(s string) string {
 	lines := strings.Split(s, "\n")
@@ -0,0 +1,246 @@
+package rename
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// StateDir is the project-local directory holding the undo journals
+const StateDir = ".renamepkg"
+
+// journalDir returns the directory holding one journal per applied rename of the module in root,
+// the newest is undone first
+func journalDir(root string) string {
+	return filepath.Join(root, StateDir, "journal")
+}
+
+// Journal records everything needed to revert an applied rename
+// Paths are relative to the module root. Staged is set when the moves went through a staging directory, they swap or rotate directories
+type Journal struct {
+	Created time.Time      `json:"created"`
+	Command []string       `json:"command"`
+	Moves   []JournalMove  `json:"moves"`
+	Staged  bool           `json:"staged,omitempty"`
+	Files   []JournalEntry `json:"files"`
+}
+
+// JournalMove is a directory move, Replaced points at the saved copy of a directory removed by --force
+type JournalMove struct {
+	From     string `json:"from"`
+	To       string `json:"to"`
+	Replaced string `json:"replaced,omitempty"`
+}
+
+// JournalEntry is a rewritten file with its original content and the hashes before and after the rename
+type JournalEntry struct {
+	Path       string      `json:"path"`
+	NewPath    string      `json:"newPath"`
+	Mode       fs.FileMode `json:"mode"`
+	Before     []byte      `json:"before"`
+	BeforeHash string      `json:"beforeHash"`
+	AfterHash  string      `json:"afterHash"`
+}
+
+// hashContent returns the hex sha256 of data
+func hashContent(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// backupPath returns where a directory replaced with --force is set aside until commit
+func backupPath(dir string) string {
+	return filepath.Join(filepath.Dir(dir), "."+filepath.Base(dir)+".renamepkg-old")
+}
+
+// recordJournal writes the undo journal of an applied plan before tx is committed
+// Directories replaced with --force are kept in the journal instead of being deleted
+// Everything it creates is removed again on rollback
+func recordJournal(tx *transaction, plan *renamePlan) error {
+	id := time.Now().UTC().Format("20060102T150405.000000000")
+	j := Journal{Created: time.Now(), Command: plan.command, Staged: plan.staged}
+
+	dir := journalDir(plan.path("."))
+	if err := mkdirAllTx(tx, dir); err != nil {
+		return err
+	}
+	// Keep the state directory out of git without touching the project's .gitignore
+	ignore := plan.path(filepath.Join(StateDir, ".gitignore"))
+	if _, err := os.Stat(ignore); os.IsNotExist(err) {
+		if err := os.WriteFile(ignore, []byte("*\n"), 0644); err != nil {
+			return err
+		}
+		tx.onUndo(func() error {
+			return removeIfExists(ignore)
+		})
+	}
+
+	for i, m := range plan.moves {
+		jm := JournalMove{From: plan.rel(m.from), To: plan.rel(m.to)}
+		if m.replace {
+			replaced := filepath.Join(dir, id, fmt.Sprintf("replaced-%d", i))
+			if err := mkdirAllTx(tx, filepath.Dir(replaced)); err != nil {
+				return err
+			}
+			backup := backupPath(m.to)
+			if err := os.Rename(backup, replaced); err != nil {
+				return err
+			}
+			tx.onUndo(func() error {
+				return os.Rename(replaced, backup)
+			})
+			jm.Replaced = plan.rel(replaced)
+		}
+		j.Moves = append(j.Moves, jm)
+	}
+
+	for _, f := range plan.files {
+		j.Files = append(j.Files, JournalEntry{
+			Path:       plan.rel(f.path),
+			NewPath:    plan.rel(f.newPath),
+			Mode:       f.mode,
+			Before:     f.before,
+			BeforeHash: hashContent(f.before),
+			AfterHash:  hashContent(f.after),
+		})
+	}
+
+	data, err := json.MarshalIndent(j, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(dir, id+".json")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return err
+	}
+	tx.onUndo(func() error {
+		return removeIfExists(path)
+	})
+	return nil
+}
+
+// latestJournal returns the path and content of the newest journal of the module in root
+func latestJournal(root string) (string, *Journal, error) {
+	dir := journalDir(root)
+	entries, err := os.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return "", nil, err
+	}
+
+	var names []string
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasSuffix(e.Name(), ".json") {
+			names = append(names, e.Name())
+		}
+	}
+	if len(names) == 0 {
+		return "", nil, fmt.Errorf("nothing to undo: no journal found in %s", dir)
+	}
+	sort.Strings(names)
+
+	path := filepath.Join(dir, names[len(names)-1])
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", nil, err
+	}
+	var j Journal
+	if err := json.Unmarshal(data, &j); err != nil {
+		return "", nil, fmt.Errorf("invalid journal %s: %v", path, err)
+	}
+	return path, &j, nil
+}
+
+// undoPlan builds the plan that reverts j in the module in root
+// It refuses if any rewritten file was edited since, or the moved directories are not where the journal left them
+func undoPlan(root string, j *Journal) (*renamePlan, error) {
+	plan := &renamePlan{dir: root, staged: j.Staged}
+	var problems []string
+
+	for i := len(j.Moves) - 1; i >= 0; i-- {
+		m := j.Moves[i]
+		from, to := plan.path(m.From), plan.path(m.To)
+		if _, err := os.Stat(to); err != nil {
+			problems = append(problems, fmt.Sprintf("directory %s is missing", to))
+		}
+		// Another move of a batch may have taken the directory over, it is moved away first
+		if _, err := os.Stat(from); err == nil && !takenOver(j.Moves, i) {
+			problems = append(problems, fmt.Sprintf("directory %s exists again", from))
+		}
+		plan.moves = append(plan.moves, dirMove{from: to, to: from})
+		if m.Replaced != "" {
+			plan.moves = append(plan.moves, dirMove{from: plan.path(m.Replaced), to: to})
+		}
+	}
+
+	for _, f := range j.Files {
+		newPath := plan.path(f.NewPath)
+		data, err := os.ReadFile(newPath)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", newPath, err))
+			continue
+		}
+		if hashContent(data) != f.AfterHash {
+			problems = append(problems, fmt.Sprintf("%s has been edited since the rename", newPath))
+			continue
+		}
+		plan.files = append(plan.files, fileChange{
+			path:    newPath,
+			newPath: plan.path(f.Path),
+			mode:    f.Mode,
+			before:  data,
+			after:   f.Before,
+		})
+	}
+
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("refusing to undo:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return plan, nil
+}
+
+// takenOver reports whether another move of moves left a directory where moves[i] came from:
+// its target is that directory, contains it or lies inside it
+func takenOver(moves []JournalMove, i int) bool {
+	from := moves[i].From
+	for k, m := range moves {
+		if k != i && (from == m.To || within(from, m.To) || within(m.To, from)) {
+			return true
+		}
+	}
+	return false
+}
+
+// undoLast reverts the newest journal of the module in root and removes it, warnings are written to out
+func undoLast(root string, out io.Writer) (*Journal, error) {
+	path, j, err := latestJournal(root)
+	if err != nil {
+		return nil, err
+	}
+	plan, err := undoPlan(root, j)
+	if err != nil {
+		return nil, err
+	}
+	plan.out = out
+
+	tx, err := plan.apply()
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.commit(); err != nil {
+		plan.warnf("failed to clean up: %v", err)
+	}
+
+	id := strings.TrimSuffix(filepath.Base(path), ".json")
+	if err := os.RemoveAll(filepath.Join(filepath.Dir(path), id)); err != nil {
+		return j, err
+	}
+	return j, os.Remove(path)
+}
@@ -0,0 +1,81 @@
+package rename
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+// applyWithJournal applies plan the way runPlan does
+func applyWithJournal(t *testing.T, plan *renamePlan) {
+	t.Helper()
+	tx, err := plan.apply()
+	if err != nil {
+		t.Fatalf("apply() error = %v", err)
+	}
+	if err := recordJournal(tx, plan); err != nil {
+		t.Fatalf("recordJournal() error = %v", err)
+	}
+	if err := tx.commit(); err != nil {
+		t.Fatalf("commit() error = %v", err)
+	}
+}
+
+func TestUndoLast(t *testing.T) {
+	t.Chdir(t.TempDir())
+	original := map[string]string{
+		"go.mod":      "module example.com/app\n",
+		"old/a.go":    "package old\n",
+		"target/x.go": "package target\n",
+		"cmd/main.go": "package main\n\nimport \"example.com/app/old\"\n",
+	}
+	writeTree(t, original)
+
+	applyWithJournal(t, &renamePlan{
+		moves: []dirMove{{from: "old", to: "target", replace: true}},
+		files: []fileChange{
+			{path: "old/a.go", newPath: "target/a.go", mode: 0644, before: []byte("package old\n"), after: []byte("package target\n")},
+			{path: "cmd/main.go", newPath: "cmd/main.go", mode: 0644, before: []byte(original["cmd/main.go"]), after: []byte("package main\n\nimport old \"example.com/app/target\"\n")},
+		},
+	})
+	if _, err := os.Stat("target/x.go"); !os.IsNotExist(err) {
+		t.Fatalf("replaced directory should be gone after the rename, stat err = %v", err)
+	}
+
+	if _, err := undoLast("", nil); err != nil {
+		t.Fatalf("undoLast() error = %v", err)
+	}
+	expected := map[string]string{".renamepkg/.gitignore": "*\n"}
+	for path, content := range original {
+		expected[path] = content
+	}
+	assertTree(t, expected)
+
+	if _, err := undoLast("", nil); err == nil || !strings.Contains(err.Error(), "nothing to undo") {
+		t.Errorf("second undoLast() error = %v, want nothing to undo", err)
+	}
+}
+
+func TestUndoRefusesEditedFiles(t *testing.T) {
+	t.Chdir(t.TempDir())
+	writeTree(t, map[string]string{
+		"go.mod":   "module example.com/app\n",
+		"old/a.go": "package old\n",
+	})
+
+	applyWithJournal(t, &renamePlan{
+		moves: []dirMove{{from: "old", to: "new"}},
+		files: []fileChange{
+			{path: "old/a.go", newPath: "new/a.go", mode: 0644, before: []byte("package old\n"), after: []byte("package new\n")},
+		},
+	})
+	writeTree(t, map[string]string{"new/a.go": "package new\n\nfunc Edited() {}\n"})
+
+	_, err := undoLast("", nil)
+	if err == nil || !strings.Contains(err.Error(), "new/a.go has been edited") {
+		t.Fatalf("undoLast() error = %v, want edited file refusal", err)
+	}
+	if _, err := os.Stat("new/a.go"); err != nil {
+		t.Errorf("refused undo should leave the tree alone: %v", err)
+	}
+}
@@ -0,0 +1,343 @@
+package rename
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// mergeMoves returns the moves of every entry of from into the existing directory to
+// Entries that already exist in to are reported as conflicts instead of being overwritten
+func mergeMoves(from, to string) ([]dirMove, []string, error) {
+	entries, err := os.ReadDir(from)
+	if err != nil {
+		return nil, nil, fmt.Errorf("source package %s is missing or unreadable: %v", from, err)
+	}
+	var moves []dirMove
+	var conflicts []string
+	for _, e := range entries {
+		src, dst := filepath.Join(from, e.Name()), filepath.Join(to, e.Name())
+		if _, err := os.Lstat(dst); err == nil {
+			conflicts = append(conflicts, fmt.Sprintf("%s: %s already exists, rename one of them before merging", src, dst))
+			continue
+		}
+		moves = append(moves, dirMove{from: src, to: dst, merge: true})
+	}
+	return moves, conflicts, nil
+}
+
+// mergePackageNames returns the declared name of the package in from and the name it takes in
+// the merged package, the one declared in to. Without Go files in to it falls back to packageNames.
+// A main package only merges with another main package.
+func mergePackageNames(plan *renamePlan, from, to, oldImport, newImport string) (string, string, error) {
+	targetPkg, err := readPackageName(to)
+	if err != nil {
+		oldPkg, newPkg := packageNames(plan, from, oldImport, newImport)
+		return oldPkg, newPkg, nil
+	}
+	oldPkg, err := readPackageName(from)
+	if err != nil {
+		plan.warnf("%v", err)
+		oldPkg = assumedPackageName(oldImport)
+	}
+	if oldPkg != targetPkg && (oldPkg == "main" || targetPkg == "main") {
+		return "", "", fmt.Errorf("cannot merge package %s into package %s", oldPkg, targetPkg)
+	}
+	return oldPkg, targetPkg, nil
+}
+
+// topLevelDecl is a package-level name declared by a file
+type topLevelDecl struct {
+	name string
+	pos  token.Position
+}
+
+// declCollisions reports the package-level names declared both by the package in from and the one
+// in to, which would clash once merged. External test packages are compared with each other.
+func declCollisions(from, to string) []string {
+	fset := token.NewFileSet()
+	target := packageDecls(fset, to)
+	var problems []string
+	for key, decls := range packageDecls(fset, from) {
+		for _, d := range decls {
+			for _, other := range target[key] {
+				if d.name == other.name {
+					problems = append(problems, fmt.Sprintf("%s:%d: %s is already declared in %s:%d", d.pos.Filename, d.pos.Line, d.name, other.pos.Filename, other.pos.Line))
+				}
+			}
+		}
+	}
+	sort.Strings(problems)
+	return problems
+}
+
+// packageDecls returns the package-level names declared by the .go files directly in dir,
+// keyed by whether they belong to the external test package
+// Methods, init functions and blank names never clash and are left out.
+func packageDecls(fset *token.FileSet, dir string) map[bool][]topLevelDecl {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	decls := make(map[bool][]topLevelDecl)
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".go") {
+			continue
+		}
+		file, err := parser.ParseFile(fset, filepath.Join(dir, e.Name()), nil, parser.SkipObjectResolution)
+		if err != nil {
+			continue
+		}
+		external := strings.HasSuffix(file.Name.Name, "_test") && strings.HasSuffix(e.Name(), "_test.go")
+		add := func(ident *ast.Ident) {
+			if ident.Name != "_" {
+				decls[external] = append(decls[external], topLevelDecl{name: ident.Name, pos: fset.Position(ident.Pos())})
+			}
+		}
+		for _, decl := range file.Decls {
+			switch decl := decl.(type) {
+			case *ast.FuncDecl:
+				if decl.Recv == nil && decl.Name.Name != "init" {
+					add(decl.Name)
+				}
+			case *ast.GenDecl:
+				for _, spec := range decl.Specs {
+					switch spec := spec.(type) {
+					case *ast.TypeSpec:
+						add(spec.Name)
+					case *ast.ValueSpec:
+						for _, name := range spec.Names {
+							add(name)
+						}
+					}
+				}
+			}
+		}
+	}
+	return decls
+}
+
+// mergeImports keeps a single import of importPath in src, now that two merged packages share it
+// The kept spec is the unnamed one if any, otherwise one whose name is not alias (the name the
+// rename introduced). References through the dropped specs are rewritten to the kept name, and
+// blank imports are dropped next to a named one. A dot import only merges with other dot imports.
+// name is the declared name of the merged package.
+func mergeImports(src, importPath, name, alias string) (string, error) {
+	specs, file, fset, err := importSpecsOf(src, importPath)
+	if err != nil || len(specs) < 2 {
+		return src, err
+	}
+
+	qualifier := func(spec *ast.ImportSpec) string {
+		if spec.Name == nil {
+			return name
+		}
+		return spec.Name.Name
+	}
+	rank := func(spec *ast.ImportSpec) int {
+		switch qualifier(spec) {
+		case "_":
+			return 4
+		case ".":
+			return 3
+		case alias:
+			if spec.Name != nil {
+				return 2
+			}
+		}
+		if spec.Name != nil {
+			return 1
+		}
+		return 0
+	}
+	keep := 0
+	for i, spec := range specs {
+		if rank(spec) < rank(specs[keep]) {
+			keep = i
+		}
+	}
+	kept := qualifier(specs[keep])
+
+	var renames []qualifierRename
+	for i, spec := range specs {
+		switch q := qualifier(spec); {
+		case i == keep, q == "_", q == kept:
+		case q == "." || kept == ".":
+			return src, fmt.Errorf("cannot merge the imports %s and %s of %s into one", kept, q, importPath)
+		default:
+			renames = append(renames, qualifierRename{importPath: importPath, oldName: q, newName: kept, named: spec.Name != nil})
+		}
+	}
+
+	// References are rewritten while the dropped specs still exist, then the specs are dropped
+	if len(renames) > 0 {
+		rewritten, err := renameQualifiers(src, renames)
+		if err != nil {
+			return src, err
+		}
+		if specs, file, fset, err = importSpecsOf(rewritten, importPath); err != nil {
+			return src, err
+		}
+		src = rewritten
+	}
+	return string(applyEdits([]byte(src), dropImportSpecs(fset, file, src, specs, specs[keep]))), nil
+}
+
+// dropSelfImport removes the imports of importPath from a file of the package at importPath, as
+// when one of two merged packages imported the other. References through them lose their
+// qualifier (b.X → X). name is the declared name of the package. It returns an error if a local
+// declaration would shadow an unqualified reference.
+func dropSelfImport(src, importPath, name string) (string, error) {
+	specs, file, fset, err := importSpecsOf(src, importPath)
+	if err != nil || len(specs) == 0 {
+		return src, err
+	}
+	qualifiers := make(map[string]bool)
+	for _, spec := range specs {
+		q := name
+		if spec.Name != nil {
+			q = spec.Name.Name
+		}
+		qualifiers[q] = q != "_" && q != "."
+	}
+
+	// Names declared outside the file scope, struct fields and interface methods aside
+	members := make(map[*ast.Ident]bool)
+	locals := make(map[string]bool)
+	ast.Inspect(file, func(n ast.Node) bool {
+		var fields *ast.FieldList
+		switch n := n.(type) {
+		case *ast.StructType:
+			fields = n.Fields
+		case *ast.InterfaceType:
+			fields = n.Methods
+		case *ast.Ident:
+			if n.Obj != nil && !members[n] && file.Scope.Lookup(n.Name) != n.Obj {
+				locals[n.Name] = true
+			}
+		}
+		if fields != nil {
+			for _, f := range fields.List {
+				for _, ident := range f.Names {
+					members[ident] = true
+				}
+			}
+		}
+		return true
+	})
+
+	var edits []edit
+	var shadowed []string
+	ast.Inspect(file, func(n ast.Node) bool {
+		sel, ok := n.(*ast.SelectorExpr)
+		if !ok {
+			return true
+		}
+		if ident, ok := sel.X.(*ast.Ident); ok && ident.Obj == nil && qualifiers[ident.Name] {
+			if locals[sel.Sel.Name] {
+				shadowed = append(shadowed, fmt.Sprintf("%s.%s", ident.Name, sel.Sel.Name))
+			}
+			edits = append(edits, edit{start: fset.Position(ident.Pos()).Offset, end: fset.Position(sel.Sel.Pos()).Offset})
+		}
+		return true
+	})
+	if len(shadowed) > 0 {
+		return src, fmt.Errorf("merging drops the import of the package itself, and %s would be shadowed by a local declaration", strings.Join(shadowed, ", "))
+	}
+	edits = append(edits, dropImportSpecs(fset, file, src, specs, nil)...)
+	return string(applyEdits([]byte(src), edits)), nil
+}
+
+// importSpecsOf parses src and returns its import specs of importPath
+func importSpecsOf(src, importPath string) ([]*ast.ImportSpec, *ast.File, *token.FileSet, error) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "", src, parser.ParseComments)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	var specs []*ast.ImportSpec
+	for _, imp := range file.Imports {
+		if p, err := strconv.Unquote(imp.Path.Value); err == nil && p == importPath {
+			specs = append(specs, imp)
+		}
+	}
+	return specs, file, fset, nil
+}
+
+// dropImportSpecs returns the edits removing specs from src, except keep
+// A spec is removed with its line comment and the ';' or newline ending it, the line too if
+// nothing else is left on it, along with a blank line if it sat between two. An import
+// declaration left without specs is removed entirely.
+func dropImportSpecs(fset *token.FileSet, file *ast.File, src string, specs []*ast.ImportSpec, keep *ast.ImportSpec) []edit {
+	dropped := make(map[ast.Spec]bool)
+	for _, spec := range specs {
+		if spec != keep {
+			dropped[spec] = true
+		}
+	}
+
+	var edits []edit
+	blank := func(c byte) bool { return c == ' ' || c == '\t' }
+	remove := func(start, end token.Pos, comment *ast.CommentGroup) {
+		if comment != nil && comment.End() > end {
+			end = comment.End()
+		}
+		s, e := fset.Position(start).Offset, fset.Position(end).Offset
+		for e < len(src) && blank(src[e]) {
+			e++
+		}
+		if e < len(src) && src[e] == ';' {
+			e++
+			for e < len(src) && blank(src[e]) {
+				e++
+			}
+		}
+		line := s
+		for line > 0 && blank(src[line-1]) {
+			line--
+		}
+		if (line == 0 || src[line-1] == '\n') && (e == len(src) || src[e] == '\n') {
+			s = line
+			if e < len(src) {
+				e++
+			}
+			// A line removed between two blank lines takes one of them along
+			if s >= 2 && src[s-2] == '\n' && e < len(src) && src[e] == '\n' {
+				e++
+			}
+		}
+		edits = append(edits, edit{start: s, end: e})
+	}
+	for _, decl := range file.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok || gen.Tok != token.IMPORT {
+			continue
+		}
+		left := 0
+		for _, spec := range gen.Specs {
+			if !dropped[spec] {
+				left++
+			}
+		}
+		if left == 0 {
+			var comment *ast.CommentGroup
+			if !gen.Lparen.IsValid() {
+				comment = gen.Specs[0].(*ast.ImportSpec).Comment
+			}
+			remove(gen.Pos(), gen.End(), comment)
+			continue
+		}
+		for _, spec := range gen.Specs {
+			if dropped[spec] {
+				remove(spec.Pos(), spec.End(), spec.(*ast.ImportSpec).Comment)
+			}
+		}
+	}
+	return edits
+}
@@ -0,0 +1,248 @@
+package rename
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestPlanMergeApplyUndo(t *testing.T) {
+	t.Chdir(t.TempDir())
+	original := map[string]string{
+		"go.mod":          "module example.com/app\n",
+		"util/u.go":       "package util\n\nfunc U() {}\n",
+		"util/u_test.go":  "package util_test\n",
+		"util/sub/s.go":   "package sub\n",
+		"target/h.go":     "package helpers\n\nfunc H() {}\n",
+		"cmd/main.go":     "package main\n\nimport (\n\t\"example.com/app/target\"\n\t\"example.com/app/util\"\n)\n\nfunc main() { util.U(); helpers.H() }\n",
+		"cmd/only.go":     "package main\n\nimport \"example.com/app/util\"\n\nfunc only() { util.U() }\n",
+		"cmd/aliased.go":  "package main\n\nimport (\n\th \"example.com/app/target\"\n\tu \"example.com/app/util\"\n)\n\nfunc aliased() { u.U(); h.H() }\n",
+		"target/t_doc.go": "// Package helpers has helpers\npackage helpers\n",
+	}
+	writeTree(t, original)
+
+	changes, err := Plan(t.Context(), Options{From: "util", To: "target", Merge: true, AllowDirty: true})
+	if err != nil {
+		t.Fatalf("Plan() error = %v", err)
+	}
+	if err := changes.Err(); err != nil {
+		t.Fatalf("Err() = %v", err)
+	}
+	if err := Apply(changes); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	got := readTree(t)
+	want := map[string]string{
+		"target/u.go":      "package helpers\n\nfunc U() {}\n",
+		"target/u_test.go": "package helpers_test\n",
+		"target/sub/s.go":  "package sub\n",
+		"target/h.go":      "package helpers\n\nfunc H() {}\n",
+		"cmd/main.go":      "package main\n\nimport (\n\t\"example.com/app/target\"\n)\n\nfunc main() { helpers.U(); helpers.H() }\n",
+		"cmd/only.go":      "package main\n\nimport util \"example.com/app/target\"\n\nfunc only() { util.U() }\n",
+		"cmd/aliased.go":   "package main\n\nimport (\n\th \"example.com/app/target\"\n)\n\nfunc aliased() { h.U(); h.H() }\n",
+	}
+	for path, content := range want {
+		if got[path] != content {
+			t.Errorf("%s = %q, want %q", path, got[path], content)
+		}
+	}
+	if _, err := os.Stat("util"); !os.IsNotExist(err) {
+		t.Errorf("util still exists after the merge: %v", err)
+	}
+
+	if _, err := Undo("", nil); err != nil {
+		t.Fatalf("Undo() error = %v", err)
+	}
+	if err := os.RemoveAll(StateDir); err != nil {
+		t.Fatal(err)
+	}
+	assertTree(t, original)
+}
+
+func TestPlanMergeConflicts(t *testing.T) {
+	t.Chdir(t.TempDir())
+	writeTree(t, map[string]string{
+		"go.mod":         "module example.com/app\n",
+		"util/doc.go":    "package util\n",
+		"util/u.go":      "package util\n\nfunc init() {}\n\nfunc U() {}\n\ntype T struct{}\n\nfunc (T) M() {}\n",
+		"target/doc.go":  "package target\n",
+		"target/t.go":    "package target\n\nfunc init() {}\n\nvar U = 1\n\ntype S struct{}\n\nfunc (S) M() {}\n",
+		"cmd/main.go":    "package main\n\nfunc main() {}\n",
+		"tool/main.go":   "package main\n",
+		"other/other.go": "package other\n",
+	})
+
+	changes, err := Plan(t.Context(), Options{From: "util", To: "target", Merge: true})
+	if err != nil {
+		t.Fatalf("Plan() error = %v", err)
+	}
+	want := []string{
+		"util/doc.go: target/doc.go already exists, rename one of them before merging",
+		"util/u.go:5: U is already declared in target/t.go:5",
+	}
+	if !reflect.DeepEqual(changes.Conflicts(), want) {
+		t.Errorf("Conflicts = %q, want %q", changes.Conflicts(), want)
+	}
+
+	for _, opts := range []Options{
+		{From: "other", To: "tool", Merge: true},
+		{From: "util", To: "target", Merge: true, Force: true},
+	} {
+		if _, err := Plan(t.Context(), opts); err == nil {
+			t.Errorf("Plan(%+v) error = nil", opts)
+		}
+	}
+}
+
+func TestMergeImports(t *testing.T) {
+	const path = "example.com/app/target"
+	tests := []struct {
+		name    string
+		input   string
+		want    string
+		wantErr bool
+	}{
+		{
+			name:  "keeps the unnamed import",
+			input: "package main\n\nimport (\n\t\"fmt\"\n\tutil \"example.com/app/target\"\n\t\"example.com/app/target\"\n)\n\nfunc main() { fmt.Println(util.U, helpers.H) }\n",
+			want:  "package main\n\nimport (\n\t\"fmt\"\n\t\"example.com/app/target\"\n)\n\nfunc main() { fmt.Println(helpers.U, helpers.H) }\n",
+		},
+		{
+			name:  "keeps an existing alias over the introduced one",
+			input: "package main\n\nimport util \"example.com/app/target\" // old\nimport h \"example.com/app/target\"\n\nvar _ = util.U\nvar _ = h.H\n",
+			want:  "package main\n\nimport h \"example.com/app/target\"\n\nvar _ = h.U\nvar _ = h.H\n",
+		},
+		{
+			name:  "drops a blank import",
+			input: "package main\n\nimport (\n\t_ \"example.com/app/target\"\n\tutil \"example.com/app/target\"\n)\n\nvar _ = util.U\n",
+			want:  "package main\n\nimport (\n\tutil \"example.com/app/target\"\n)\n\nvar _ = util.U\n",
+		},
+		{
+			name:  "leaves locals alone",
+			input: "package main\n\nimport (\n\tutil \"example.com/app/target\"\n\t\"example.com/app/target\"\n)\n\nfunc f(util struct{ U int }) int { return util.U + helpers.H }\n\nvar _ = util.U\n",
+			want:  "package main\n\nimport (\n\t\"example.com/app/target\"\n)\n\nfunc f(util struct{ U int }) int { return util.U + helpers.H }\n\nvar _ = helpers.U\n",
+		},
+		{
+			name:  "single import",
+			input: "package main\n\nimport util \"example.com/app/target\"\n\nvar _ = util.U\n",
+			want:  "package main\n\nimport util \"example.com/app/target\"\n\nvar _ = util.U\n",
+		},
+		{
+			name:  "specs sharing a line",
+			input: "package main\n\nimport (u \"example.com/app/target\"; \"example.com/app/target\")\n\nvar _ = u.U\n",
+			want:  "package main\n\nimport (\"example.com/app/target\")\n\nvar _ = helpers.U\n",
+		},
+		{
+			name:  "last spec on a shared line",
+			input: "package main\n\nimport (\"example.com/app/target\"; util \"example.com/app/target\"; \"fmt\")\n\nvar _ = fmt.Sprint(util.U)\n",
+			want:  "package main\n\nimport (\"example.com/app/target\"; \"fmt\")\n\nvar _ = fmt.Sprint(helpers.U)\n",
+		},
+		{
+			name:    "kept name used by a local",
+			input:   "package main\n\nimport (\n\tutil \"example.com/app/target\"\n\t\"example.com/app/target\"\n)\n\nfunc f() int { helpers := util.U; return helpers }\n",
+			wantErr: true,
+		},
+		{
+			name:    "dot import",
+			input:   "package main\n\nimport (\n\t. \"example.com/app/target\"\n\tutil \"example.com/app/target\"\n)\n\nvar _ = util.U\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := mergeImports(tt.input, path, "helpers", "util")
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("mergeImports() expected error, got \n%v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("mergeImports() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("mergeImports() = \n%v\n, want \n%v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPlanMergeMutualImports(t *testing.T) {
+	t.Chdir(t.TempDir())
+	writeTree(t, map[string]string{
+		"go.mod":      "module example.com/app\n",
+		"a/a.go":      "package a\n\nimport \"example.com/app/b\"\n\nfunc A() int { return b.B }\n",
+		"b/b.go":      "package b\n\nvar B = 1\n",
+		"b/c.go":      "package b\n\nimport (\n\t\"fmt\"\n\n\t\"example.com/app/a\"\n)\n\nfunc C() { fmt.Println(a.A()) }\n",
+		"b/b_test.go": "package b_test\n\nimport \"example.com/app/b\"\n\nvar _ = b.B\n",
+	})
+
+	changes, err := Plan(t.Context(), Options{From: "a", To: "b", Merge: true})
+	if err != nil {
+		t.Fatalf("Plan() error = %v", err)
+	}
+	if err := changes.Err(); err != nil {
+		t.Fatalf("Err() = %v", err)
+	}
+	want := map[string]string{
+		"a/a.go": "package b\n\nfunc A() int { return B }\n",
+		"b/c.go": "package b\n\nimport (\n\t\"fmt\"\n)\n\nfunc C() { fmt.Println(A()) }\n",
+	}
+	files := changes.Files()
+	if len(files) != len(want) {
+		t.Errorf("Files = %+v, want a/a.go and b/c.go", files)
+	}
+	for _, f := range files {
+		if string(f.After) != want[f.Path] {
+			t.Errorf("%s = %q, want %q", f.Path, f.After, want[f.Path])
+		}
+	}
+}
+
+func TestDropSelfImport(t *testing.T) {
+	const path = "example.com/app/b"
+	tests := []struct {
+		name    string
+		input   string
+		want    string
+		wantErr bool
+	}{
+		{
+			name:  "unnamed import",
+			input: "package b\n\nimport (\n\t\"fmt\"\n\t\"example.com/app/b\"\n)\n\nvar _ = fmt.Sprint(b.B)\n",
+			want:  "package b\n\nimport (\n\t\"fmt\"\n)\n\nvar _ = fmt.Sprint(B)\n",
+		},
+		{
+			name:  "aliased import",
+			input: "package b\n\nimport a \"example.com/app/b\"\n\nvar _ = a.A\n",
+			want:  "package b\n\nvar _ = A\n",
+		},
+		{
+			name:  "fields and methods do not shadow",
+			input: "package b\n\nimport \"example.com/app/b\"\n\ntype T struct{ B int }\n\ntype I interface{ B() }\n\nvar _ = b.B\n",
+			want:  "package b\n\ntype T struct{ B int }\n\ntype I interface{ B() }\n\nvar _ = B\n",
+		},
+		{
+			name:    "shadowed by a local",
+			input:   "package b\n\nimport \"example.com/app/b\"\n\nfunc f() int { B := 2; return B + b.B }\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := dropSelfImport(tt.input, path, "b")
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("dropSelfImport() expected error, got \n%v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("dropSelfImport() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("dropSelfImport() = \n%v\n, want \n%v", got, tt.want)
+			}
+		})
+	}
+}
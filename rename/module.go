@@ -0,0 +1,224 @@
+package rename
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// moduleRewrite is what planModuleImports rewrites in the files of every module it walks
+// Imports of the keep modules are left alone, files already in seen are skipped. With moves the
+// package moves are rewritten first, against the old module path.
+type moduleRewrite struct {
+	oldModule, newModule string
+	keep                 []string
+	filter               *walkFilter
+	seen                 map[string]bool
+	gofmt                bool
+	moves                *batchRename
+}
+
+// planModuleRename plans renaming all imports from oldModule to opts.Module across all .go files
+// Inside a go.work workspace the other member modules are rewritten too, along with their
+// go.mod files and the go.work replace directives
+// Modules nested in subdirectories are only rewritten with opts.NestedModules or when they are
+// workspace members; the others keep their module path, so imports of them are left alone
+// The changes are added to plan, package moves of a batch are rewritten in the same pass
+// It returns the walked files
+func planModuleRename(ctx context.Context, plan *renamePlan, opts Options, filter *walkFilter, oldModule string, moves *batchRename) ([]goFile, error) {
+	root := plan.path(".")
+	ws, err := findWorkspace(root)
+	if err != nil {
+		return nil, err
+	}
+	nestedModules, err := findNestedModules(root, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	// Modules rewritten along with the root module, each matched against its own go.mod
+	var members []string
+	isMember := make(map[string]bool)
+	if ws != nil {
+		plan.logf("  Workspace: %s (%d modules)\n", ws.path, len(ws.members))
+		for _, member := range ws.members {
+			if member == root {
+				continue
+			}
+			members = append(members, member)
+			isMember[filepath.Clean(member)] = true
+		}
+	}
+	rw := &moduleRewrite{
+		oldModule: oldModule,
+		newModule: filepath.ToSlash(opts.Module),
+		filter:    filter,
+		seen:      make(map[string]bool),
+		gofmt:     opts.Gofmt,
+		moves:     moves,
+	}
+	for _, m := range nestedModules {
+		if isMember[m.dir] {
+			continue
+		}
+		if !opts.NestedModules {
+			plan.logf("  Skipping nested module %s (%s), use --nested-modules to include it\n", m.dir, m.path)
+			rw.keep = append(rw.keep, m.path)
+			continue
+		}
+		plan.logf("  Nested module: %s (%s)\n", m.dir, m.path)
+		members = append(members, m.dir)
+		isMember[m.dir] = true
+	}
+
+	// Search all .go files in the project directory and replace import statements
+	// Members are walked only once, even if a member directory is listed twice
+	files, err := planModuleImports(ctx, plan, root, rw)
+	if err != nil {
+		return nil, err
+	}
+
+	// Update go.mod file with new module path and the directives pointing at nested modules
+	goMod := plan.path("go.mod")
+	if err := planFileUpdate(plan, goMod, func(data []byte) ([]byte, error) {
+		return updateGoMod(goMod, data, rw.oldModule, rw.newModule, rw.keep...)
+	}); err != nil {
+		return nil, fmt.Errorf("failed to update %s: %v", goMod, err)
+	}
+
+	for _, member := range members {
+		goMod := filepath.Join(member, "go.mod")
+		abs, err := filepath.Abs(goMod)
+		if err != nil {
+			return nil, err
+		}
+		if rw.seen[abs] {
+			continue
+		}
+		rw.seen[abs] = true
+
+		memberFiles, err := planModuleImports(ctx, plan, member, rw)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, memberFiles...)
+		if err := planFileUpdate(plan, goMod, func(data []byte) ([]byte, error) {
+			return updateGoMod(goMod, data, rw.oldModule, rw.newModule, rw.keep...)
+		}); err != nil {
+			return nil, fmt.Errorf("failed to update %s: %v", goMod, err)
+		}
+	}
+	if ws != nil {
+		if err := planFileUpdate(plan, ws.path, func(data []byte) ([]byte, error) {
+			return updateGoWork(ws.path, data, rw.oldModule, rw.newModule, rw.keep...)
+		}); err != nil {
+			return nil, fmt.Errorf("failed to update %s: %v", ws.path, err)
+		}
+	}
+
+	// Consumers vendoring the renamed module get their vendor directory updated,
+	// a workspace vendors at the go.work level
+	vendorDirs := []string{plan.path("vendor")}
+	for _, member := range members {
+		vendorDirs = append(vendorDirs, filepath.Join(member, "vendor"))
+	}
+	if ws != nil {
+		vendorDirs = append(vendorDirs, filepath.Join(filepath.Dir(ws.path), "vendor"))
+	}
+	vendored := make(map[string]bool)
+	for _, dir := range vendorDirs {
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			return nil, err
+		}
+		if vendored[abs] {
+			continue
+		}
+		vendored[abs] = true
+		if err := planVendorRename(ctx, plan, dir, rw.oldModule, rw.newModule, rw.keep); err != nil {
+			return nil, err
+		}
+	}
+
+	return files, nil
+}
+
+// planModuleImports plans the rewrite rw of every .go file of the module at root
+// It returns the walked files.
+func planModuleImports(ctx context.Context, plan *renamePlan, root string, rw *moduleRewrite) ([]goFile, error) {
+	var files []goFile
+	err := walkGoFiles(root, rw.filter, func(path string, info fs.FileInfo) error {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return err
+		}
+		if !rw.seen[abs] {
+			rw.seen[abs] = true
+			files = append(files, goFile{path: path, info: info})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Files that never mention the module are skipped before parsing, unless a package clause changes
+	needle := []byte(rw.oldModule)
+	return files, plan.planRewrites(ctx, files, func(path string, data []byte) (rewriteResult, error) {
+		if !bytes.Contains(data, needle) && (rw.moves == nil || !rw.moves.renamesPackage(path)) {
+			return rewriteResult{after: data}, nil
+		}
+		var result rewriteResult
+		updated := string(data)
+		if rw.moves != nil {
+			var err error
+			if updated, result.conflicts, err = rw.moves.rewrite(path, updated); err != nil {
+				return skipFile(path, data, err), nil
+			}
+		}
+		updated, err := replaceModuleImports(updated, rw.oldModule, rw.newModule, rw.keep...)
+		if err != nil {
+			return skipFile(path, data, err), nil
+		}
+		result.after = data
+		if updated == string(data) {
+			return result, nil
+		}
+		formatted, err := formatUpdated(path, data, []byte(updated), rw.gofmt)
+		if err != nil {
+			result.warnings = append(result.warnings, err.Error())
+		}
+		result.after = formatted
+		return result, nil
+	})
+}
+
+// planFileUpdate plans rewriting a non-Go file such as go.mod or go.work with update
+func planFileUpdate(plan *renamePlan, path string, update func(data []byte) ([]byte, error)) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %v", path, err)
+	}
+
+	updated, err := update(data)
+	if err != nil {
+		return err
+	}
+	if string(updated) != string(data) {
+		plan.addFile(fileChange{
+			path:    path,
+			newPath: path,
+			mode:    info.Mode(),
+			before:  data,
+			after:   updated,
+		})
+	}
+	return nil
+}
@@ -0,0 +1,269 @@
+package rename
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"go/token"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// planPackageRename plans moving the package in opts.From to opts.To and rewriting every import of it
+// The changes and the renamed import paths are added to plan
+func planPackageRename(ctx context.Context, plan *renamePlan, opts Options, filter *walkFilter) error {
+	// Read module from go.mod if not provided
+	modulePath := opts.ModulePath
+	if modulePath == "" {
+		var err error
+		modulePath, err = readModuleFromGoMod(plan.path("."))
+		if err != nil {
+			return err
+		}
+	}
+	rootModule := modulePath
+
+	oldFullPath := plan.path(opts.From)
+	newFullPath := plan.path(opts.To)
+	move := dirMove{from: oldFullPath, to: newFullPath}
+
+	if _, err := os.Stat(oldFullPath); err != nil {
+		return fmt.Errorf("failed to rename folder: %v", err)
+	}
+
+	if opts.Merge && opts.Force {
+		return fmt.Errorf("-merge and -force cannot be used together")
+	}
+
+	// Check if target directory exists
+	merge := false
+	if _, err := os.Stat(newFullPath); err == nil {
+		switch {
+		case opts.Merge:
+			merge = true
+		case !opts.Force:
+			return fmt.Errorf("Target directory %s already exists.\nUse -force to overwrite it, -merge to merge into it, or swap the two packages with renamepkg apply.", newFullPath)
+		default:
+			move.replace = true
+		}
+	}
+
+	// Nested packages move along with the directory, so their imports need rewriting too
+	nested := hasNestedPackages(oldFullPath)
+
+	// A package inside a nested module is imported through that module's own path
+	nestedModules, err := findNestedModules(plan.path("."), filter)
+	if err != nil {
+		return err
+	}
+	owner := moduleOf(oldFullPath, nestedModules)
+	if owner != moduleOf(newFullPath, nestedModules) {
+		return fmt.Errorf("%s and %s belong to different modules", oldFullPath, newFullPath)
+	}
+	fromSlash := filepath.ToSlash(opts.From)
+	toSlash := filepath.ToSlash(opts.To)
+	if owner != nil {
+		if !opts.NestedModules {
+			return fmt.Errorf("%s belongs to the nested module %s (%s).\nUse --nested-modules or run renamepkg inside %s.", oldFullPath, owner.path, owner.dir, owner.dir)
+		}
+		if oldFullPath == owner.dir {
+			return fmt.Errorf("%s is the root of module %s, rename it with -mod inside %s", oldFullPath, owner.path, owner.dir)
+		}
+		modulePath = owner.path
+		fromSlash = filepath.ToSlash(strings.TrimPrefix(oldFullPath, owner.dir+string(filepath.Separator)))
+		toSlash = filepath.ToSlash(strings.TrimPrefix(newFullPath, owner.dir+string(filepath.Separator)))
+	}
+
+	// Build import paths
+	// Construct full import paths: modulePath/from -> modulePath/to
+	modSlash := filepath.ToSlash(modulePath)
+
+	// Build full import paths
+	oldImport := modSlash + "/" + fromSlash
+	newImport := modSlash + "/" + toSlash
+
+	var oldPkg, newPkg string
+	if merge {
+		// The merged files take the package name of the target
+		if oldPkg, newPkg, err = mergePackageNames(plan, oldFullPath, newFullPath, oldImport, newImport); err != nil {
+			return err
+		}
+		plan.logf("Merge into the existing package %s in %s:\n", newPkg, newFullPath)
+	} else if hasGoFiles(oldFullPath) {
+		oldPkg, newPkg = packageNames(plan, oldFullPath, oldImport, newImport)
+	}
+
+	// Importers only need an alias if the declared package name changes
+	needAlias := oldPkg != newPkg
+
+	plan.logf("Rename import:\n")
+	plan.addImportRename(oldImport, newImport, oldPkg, newPkg, opts.RewriteRefs, nested)
+
+	// Search all .go files in the project directory (execution directory, not package directory)
+	// and plan the import replacements against the current layout
+	// A target directory replaced with --force is removed, so its files are skipped
+	if merge {
+		moves, conflicts, err := mergeMoves(oldFullPath, newFullPath)
+		if err != nil {
+			return err
+		}
+		plan.addMoves(moves...)
+		plan.addConflicts(conflicts...)
+		plan.addConflicts(declCollisions(oldFullPath, newFullPath)...)
+	} else {
+		plan.addMoves(move)
+	}
+	if move.replace {
+		filter.skip = append(filter.skip, newFullPath)
+	}
+	roots := []string{plan.path(".")}
+	if opts.NestedModules {
+		for _, m := range nestedModules {
+			roots = append(roots, m.dir)
+		}
+	}
+	var files []goFile
+	for _, root := range roots {
+		err := walkGoFiles(root, filter, func(path string, info fs.FileInfo) error {
+			files = append(files, goFile{path: path, info: info})
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	// Only files mentioning the old import path or declaring the renamed package are parsed
+	needle := []byte(oldImport)
+	err = plan.planRewrites(ctx, files, func(path string, data []byte) (rewriteResult, error) {
+		inPackage := filepath.Dir(path) == oldFullPath
+		if !inPackage && !bytes.Contains(data, needle) {
+			return rewriteResult{after: data}, nil
+		}
+
+		originalContent := string(data)
+		var result rewriteResult
+
+		// Replace import statements
+		updated, err := replaceImports(originalContent, oldImport, newImport, oldPkg, newPkg)
+		if err != nil {
+			return skipFile(path, data, err), nil
+		}
+		merged := false
+		if merge && updated != originalContent {
+			// Importers of both packages keep a single import of the merged package
+			deduped, err := mergeImports(updated, newImport, newPkg, oldPkg)
+			if err != nil {
+				result.conflicts = append(result.conflicts, fmt.Sprintf("%s: %v", path, err))
+			}
+			merged = deduped != updated
+			updated = deduped
+		}
+		if needAlias && opts.RewriteRefs && updated != originalContent && !merged {
+			// Drop the alias and rewrite di.Foo → difish.Foo instead
+			unaliased, _ := replaceImports(originalContent, oldImport, newImport, newPkg, newPkg)
+			rewritten, err := rewriteQualifiedRefs(unaliased, newImport, oldPkg, newPkg)
+			switch conflict := packageScopeConflict(path, rewritten, newPkg); {
+			case err != nil:
+				result.conflicts = append(result.conflicts, fmt.Sprintf("%s: %v, run without --rewrite-refs to keep the alias", path, err))
+			case rewritten != unaliased && conflict != "":
+				result.conflicts = append(result.conflicts, fmt.Sprintf("%s: %s, run without --rewrite-refs to keep the alias", path, conflict))
+			default:
+				updated = rewritten
+			}
+		}
+
+		// If directly inside the renamed package dir, update `package xxx` and `package xxx_test`
+		// Nested packages keep their own names
+		if inPackage && needAlias {
+			updated = rewritePackageClause(updated, oldPkg, newPkg)
+		}
+
+		// A merged package that imported the other one now imports itself
+		if merge && (inPackage || filepath.Dir(path) == newFullPath) && filePackageName(updated) == newPkg {
+			selfless, err := dropSelfImport(updated, newImport, newPkg)
+			if err != nil {
+				result.conflicts = append(result.conflicts, fmt.Sprintf("%s: %v", path, err))
+			}
+			updated = selfless
+		}
+
+		// Untouched files are left byte-identical, they only move along with their directory
+		result.after = data
+		if updated != originalContent {
+			formatted, err := formatUpdated(path, data, []byte(updated), opts.Gofmt)
+			if err != nil {
+				result.warnings = append(result.warnings, err.Error())
+			}
+			result.after = formatted
+		}
+		return result, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Check the layout after the rename for broken imports before anything moves
+	check := &conflictCheck{plan: plan, files: files}
+	check.importPath = func(dir string) string {
+		base, rel := filepath.ToSlash(rootModule), filepath.ToSlash(plan.rel(dir))
+		if m := moduleOf(dir, nestedModules); m != nil {
+			base = m.path
+			rel = filepath.ToSlash(strings.TrimPrefix(strings.TrimPrefix(dir, m.dir), string(filepath.Separator)))
+		}
+		if rel == "." || rel == "" {
+			return base
+		}
+		return base + "/" + rel
+	}
+	check.currentPath = check.importPath
+	plan.addConflicts(check.conflicts()...)
+	return nil
+}
+
+// packageNames returns the declared name of the package in dir and the name it gets at newImport
+// The declared name may differ from the directory name (e.g. go-yaml declares yaml)
+// The new name follows the new directory when its name changes, except for main packages
+func packageNames(plan *renamePlan, dir, oldImport, newImport string) (string, string) {
+	oldPkg, err := readPackageName(dir)
+	if err != nil {
+		plan.warnf("%v", err)
+		oldPkg = assumedPackageName(oldImport)
+	}
+	newPkg := oldPkg
+	if oldPkg != "main" && assumedPackageName(newImport) != assumedPackageName(oldImport) {
+		newPkg = assumedPackageName(newImport)
+		if !token.IsIdentifier(newPkg) {
+			plan.warnf("%s is not a valid package name, keeping package %s", newPkg, oldPkg)
+			newPkg = oldPkg
+		}
+	}
+	return oldPkg, newPkg
+}
+
+// addImportRename records a renamed import path and prints it
+// Importers keep the old name as alias when it changes, unless rewriteRefs rewrites their references
+func (p *renamePlan) addImportRename(oldImport, newImport, oldPkg, newPkg string, rewriteRefs, nested bool) {
+	needAlias := oldPkg != newPkg
+	if needAlias && rewriteRefs {
+		p.logf("  \"%s\" → \"%s\" (%s.X → %s.X)\n", oldImport, newImport, oldPkg, newPkg)
+	} else if needAlias {
+		p.logf("  \"%s\" → %s \"%s\"\n", oldImport, oldPkg, newImport)
+	} else {
+		p.logf("  \"%s\" → \"%s\"\n", oldImport, newImport)
+	}
+	if nested {
+		p.logf("  \"%s/...\" → \"%s/...\"\n", oldImport, newImport)
+	}
+
+	rename := ImportRename{Old: oldImport, New: newImport}
+	if needAlias && !rewriteRefs {
+		rename.Alias = oldPkg
+	}
+	p.addImports(rename)
+	if nested {
+		p.addImports(ImportRename{Old: oldImport + "/...", New: newImport + "/..."})
+	}
+}
@@ -0,0 +1,102 @@
+package rename
+
+import (
+	"fmt"
+	"go/parser"
+	"go/token"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// readPackageName returns the package name declared by the .go files directly in dir
+// External test packages (name_test) are ignored; if files disagree the most common name wins
+func readPackageName(dir string) (string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+
+	counts := make(map[string]int)
+	best := ""
+	fset := token.NewFileSet()
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".go") {
+			continue
+		}
+		file, err := parser.ParseFile(fset, filepath.Join(dir, e.Name()), nil, parser.PackageClauseOnly)
+		if err != nil {
+			continue
+		}
+		name := file.Name.Name
+		if strings.HasSuffix(name, "_test") && strings.HasSuffix(e.Name(), "_test.go") {
+			continue
+		}
+		counts[name]++
+		if counts[name] > counts[best] || (counts[name] == counts[best] && name < best) {
+			best = name
+		}
+	}
+
+	if best == "" {
+		return "", fmt.Errorf("no package clause found in %s", dir)
+	}
+	return best, nil
+}
+
+// assumedPackageName returns the package name conventionally used for importPath
+// Version suffixes like /v2 are skipped, a go- prefix is dropped and the name is cut at the
+// first character that is not valid in an identifier (e.g. go-yaml → yaml, redis/v9 → redis)
+func assumedPackageName(importPath string) string {
+	base := path.Base(importPath)
+	if strings.HasPrefix(base, "v") {
+		if _, err := strconv.Atoi(base[1:]); err == nil {
+			if dir := path.Dir(importPath); dir != "." {
+				base = path.Base(dir)
+			}
+		}
+	}
+	base = strings.TrimPrefix(base, "go-")
+	if i := strings.IndexFunc(base, func(r rune) bool {
+		return r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}); i >= 0 {
+		base = base[:i]
+	}
+	return base
+}
+
+// filePackageName returns the package name declared by src, or "" if it cannot be parsed
+func filePackageName(src string) string {
+	file, err := parser.ParseFile(token.NewFileSet(), "", src, parser.PackageClauseOnly)
+	if err != nil {
+		return ""
+	}
+	return file.Name.Name
+}
+
+// rewritePackageClause renames `package oldName` to `package newName` and the external test
+// package `package oldName_test` to `package newName_test`, keeping the rest of src byte-for-byte
+func rewritePackageClause(src, oldName, newName string) string {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "", src, parser.PackageClauseOnly)
+	if err != nil {
+		return src
+	}
+
+	var name string
+	switch file.Name.Name {
+	case oldName:
+		name = newName
+	case oldName + "_test":
+		name = newName + "_test"
+	default:
+		return src
+	}
+
+	start := fset.Position(file.Name.Pos()).Offset
+	end := fset.Position(file.Name.End()).Offset
+	return string(applyEdits([]byte(src), []edit{{start: start, end: end, text: name}}))
+}
@@ -0,0 +1,84 @@
+package rename
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadPackageName(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"yaml.go":      "package yaml\n",
+		"decode.go":    "// Package yaml decodes.\npackage yaml\n",
+		"yaml_test.go": "package yaml_test\n",
+		"doc.txt":      "package nope\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	name, err := readPackageName(dir)
+	if err != nil {
+		t.Fatalf("readPackageName() error = %v", err)
+	}
+	if name != "yaml" {
+		t.Errorf("readPackageName() = %q, want %q", name, "yaml")
+	}
+
+	if _, err := readPackageName(t.TempDir()); err == nil {
+		t.Error("readPackageName() on an empty directory expected error")
+	}
+}
+
+func TestAssumedPackageName(t *testing.T) {
+	tests := map[string]string{
+		"internal/server/difish":         "difish",
+		"github.com/go-yaml/go-yaml":     "yaml",
+		"github.com/redis/go-redis/v9":   "redis",
+		"example.com/app/v2":             "app",
+		"example.com/app/pkg.v3":         "pkg",
+		"example.com/app/under_score":    "under_score",
+		"example.com/app/kebab-case-pkg": "kebab",
+	}
+	for importPath, expected := range tests {
+		if got := assumedPackageName(importPath); got != expected {
+			t.Errorf("assumedPackageName(%q) = %q, want %q", importPath, got, expected)
+		}
+	}
+}
+
+func TestRewritePackageClause(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			name:     "package clause",
+			input:    "// Package di wires things.\npackage di // import \"example.com/app/di\"\n",
+			expected: "// Package di wires things.\npackage difish // import \"example.com/app/di\"\n",
+		},
+		{
+			name:     "external test package",
+			input:    "package di_test\n\nimport \"testing\"\n",
+			expected: "package difish_test\n\nimport \"testing\"\n",
+		},
+		{
+			name:     "other package",
+			input:    "package dix\n",
+			expected: "package dix\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := rewritePackageClause(tt.input, "di", "difish")
+			if result != tt.expected {
+				t.Errorf("rewritePackageClause() = %q, want %q", result, tt.expected)
+			}
+		})
+	}
+}
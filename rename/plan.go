@@ -0,0 +1,481 @@
+package rename
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// fileChange is the planned content of a single changed file
+// path is where the file lives before the rename, newPath where it ends up after directory moves
+type fileChange struct {
+	path    string
+	newPath string
+	mode    fs.FileMode
+	before  []byte
+	after   []byte
+}
+
+// dirMove is a planned directory rename
+// replace is set when the target exists and is removed first (--force)
+// merge is set for an entry of a directory merged into an existing one (--merge)
+type dirMove struct {
+	from    string
+	to      string
+	replace bool
+	merge   bool
+}
+
+// renamePlan collects every change of a rename before anything touches the filesystem
+// conflicts are problems found while planning that make the rename unsafe to apply
+// staged moves every directory through a staging directory, for moves that swap or rotate directories
+// Progress messages and warnings go to out, a nil out discards them
+// events, if set, receives every change as soon as it is planned
+// dir is the module root, every path of the plan starts with it; the working directory if empty
+// command describes the rename in its undo journal
+type renamePlan struct {
+	dir            string
+	command        []string
+	imports        []ImportRename
+	moves          []dirMove
+	staged         bool
+	files          []fileChange
+	warnings       []string
+	conflicts      []string
+	filesProcessed int
+	out            io.Writer
+	events         func(Event)
+}
+
+// path returns name, relative to the module root, as the plan refers to it
+func (p *renamePlan) path(name string) string {
+	return filepath.Join(p.dir, name)
+}
+
+// rel returns a path of the plan relative to the module root
+func (p *renamePlan) rel(path string) string {
+	if rel, err := filepath.Rel(p.path("."), path); err == nil {
+		return rel
+	}
+	return path
+}
+
+// conflictErr returns an error listing the conflicts of the plan, or nil if there are none
+func (p *renamePlan) conflictErr() error {
+	if len(p.conflicts) == 0 {
+		return nil
+	}
+	return fmt.Errorf("the rename would break the build, nothing was changed:\n  %s", strings.Join(p.conflicts, "\n  "))
+}
+
+// logf writes a progress message to the plan's output
+func (p *renamePlan) logf(format string, args ...any) {
+	if p.out != nil {
+		fmt.Fprintf(p.out, format, args...)
+	}
+}
+
+// warnf records a warning and writes it to the plan's output
+func (p *renamePlan) warnf(format string, args ...any) {
+	msg := fmt.Sprintf(format, args...)
+	p.warnings = append(p.warnings, msg)
+	p.logf("Warning: %s\n", msg)
+	p.emit(Event{Type: "warning", Message: msg})
+}
+
+// emit passes e to the plan's events, if any
+func (p *renamePlan) emit(e Event) {
+	if p.events != nil {
+		p.events(e)
+	}
+}
+
+// addImports records renamed import paths
+func (p *renamePlan) addImports(imports ...ImportRename) {
+	for _, i := range imports {
+		p.imports = append(p.imports, i)
+		p.emit(Event{Type: "import", Import: &i})
+	}
+}
+
+// addMoves records planned directory moves
+func (p *renamePlan) addMoves(moves ...dirMove) {
+	for _, m := range moves {
+		p.moves = append(p.moves, m)
+		mr := moveReport(m)
+		p.emit(Event{Type: "move", Move: &mr})
+	}
+}
+
+// addFile records a planned file change
+func (p *renamePlan) addFile(f fileChange) {
+	p.files = append(p.files, f)
+	if p.events == nil {
+		return
+	}
+	fr, goMod := fileReport(f)
+	typ := "file"
+	if goMod {
+		typ = "gomod"
+	}
+	p.emit(Event{Type: typ, File: &fr})
+}
+
+// addConflicts records problems that make the rename unsafe to apply
+func (p *renamePlan) addConflicts(conflicts ...string) {
+	for _, c := range conflicts {
+		p.conflicts = append(p.conflicts, c)
+		p.emit(Event{Type: "conflict", Message: c})
+	}
+}
+
+// goFile is a .go file found by walkGoFiles
+type goFile struct {
+	path string
+	info fs.FileInfo
+}
+
+// rewriteResult is the outcome of a rewriteFunc for one file
+// after is the new content, or the original data if the file stays unchanged
+type rewriteResult struct {
+	after     []byte
+	warnings  []string
+	conflicts []string
+}
+
+// skipFile is the result for a file that cannot be rewritten, left unchanged with a warning
+func skipFile(path string, data []byte, err error) rewriteResult {
+	return rewriteResult{after: data, warnings: []string{fmt.Sprintf("skipping %s: %v", path, err)}}
+}
+
+// rewriteFunc rewrites the content of the file at path
+// It runs concurrently for different files and must not touch shared state
+type rewriteFunc func(path string, data []byte) (rewriteResult, error)
+
+// planRewrites reads files and runs rewrite over them on a bounded pool of workers, planning
+// every changed file as soon as the ones before it are done. Changes, warnings and conflicts are
+// collected in the order of files, so the output does not depend on scheduling. Files left when
+// ctx is cancelled are not read.
+func (p *renamePlan) planRewrites(ctx context.Context, files []goFile, rewrite rewriteFunc) error {
+	type result struct {
+		rewriteResult
+		before []byte
+		err    error
+		done   chan struct{}
+	}
+	results := make([]result, len(files))
+	for i := range results {
+		results[i].done = make(chan struct{})
+	}
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for range min(runtime.GOMAXPROCS(0), len(files)) {
+		wg.Go(func() {
+			for i := range jobs {
+				r := &results[i]
+				if r.err = ctx.Err(); r.err == nil {
+					if r.before, r.err = os.ReadFile(files[i].path); r.err == nil {
+						r.rewriteResult, r.err = rewrite(files[i].path, r.before)
+					}
+				}
+				close(r.done)
+			}
+		})
+	}
+	wg.Go(func() {
+		for i := range files {
+			jobs <- i
+		}
+		close(jobs)
+	})
+
+	p.filesProcessed += len(files)
+	for i := range results {
+		r := &results[i]
+		<-r.done
+		for _, w := range r.warnings {
+			p.warnf("%s", w)
+		}
+		if r.err != nil {
+			return r.err
+		}
+		p.addConflicts(r.conflicts...)
+		// Untouched files are left byte-identical
+		if bytes.Equal(r.after, r.before) {
+			continue
+		}
+		p.addFile(fileChange{
+			path:    files[i].path,
+			newPath: p.movedPath(files[i].path),
+			mode:    files[i].info.Mode(),
+			before:  r.before,
+			after:   r.after,
+		})
+	}
+	return nil
+}
+
+// movedPath returns where path ends up after the planned directory moves
+// A path inside several moved directories follows the innermost one
+func (p *renamePlan) movedPath(path string) string {
+	moved, depth := path, -1
+	for _, m := range p.moves {
+		if rel, err := filepath.Rel(m.from, path); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) && len(m.from) > depth {
+			moved, depth = filepath.Join(m.to, rel), len(m.from)
+		}
+	}
+	return moved
+}
+
+// writeDiff writes the planned directory moves and a unified diff per file to w without touching the filesystem
+func (p *renamePlan) writeDiff(w io.Writer) {
+	if p.staged {
+		fmt.Fprintf(w, "The moves swap or rotate directories, each one goes through a staging directory\n")
+	}
+	for _, m := range p.moves {
+		if m.replace {
+			fmt.Fprintf(w, "Remove directory: %s\n", m.to)
+		}
+		if m.merge {
+			fmt.Fprintf(w, "Merge: %s → %s\n", m.from, m.to)
+			continue
+		}
+		fmt.Fprintf(w, "Move directory: %s → %s\n", m.from, m.to)
+	}
+	if len(p.moves) > 0 {
+		fmt.Fprintln(w)
+	}
+
+	for _, f := range p.files {
+		fmt.Fprint(w, unifiedDiff("a/"+filepath.ToSlash(f.path), "b/"+filepath.ToSlash(f.newPath), f.before, f.after))
+	}
+}
+
+// apply stages the plan as a transaction
+// New contents are written to temp files next to the originals first, then directories are
+// moved and the temp files renamed into place. If any step fails, everything done so far is
+// rolled back. On success the caller must commit or roll back the returned transaction.
+func (p *renamePlan) apply() (*transaction, error) {
+	tx := &transaction{}
+	if err := p.stage(tx); err != nil {
+		if rbErr := tx.rollback(); rbErr != nil {
+			return nil, fmt.Errorf("%v (%v)", err, rbErr)
+		}
+		return nil, err
+	}
+	return tx, nil
+}
+
+// stage applies every step of the plan and registers its undo with tx
+// Undo steps run in reverse, so directories are moved back before temp files are removed
+func (p *renamePlan) stage(tx *transaction) error {
+	// Step 1: write the new contents to temp files
+	temps := make([]string, len(p.files))
+	for i, f := range p.files {
+		tmp, err := writeTemp(f.path, f.after, f.mode)
+		if err != nil {
+			return fmt.Errorf("failed to stage %s: %v", f.path, err)
+		}
+		temps[i] = tmp
+		tx.onUndo(func() error {
+			return removeIfExists(tmp)
+		})
+	}
+
+	// Steps 2-4: move the directories, through a staging directory if they swap or rotate
+	if p.staged {
+		if err := p.stageMoves(tx); err != nil {
+			return err
+		}
+	} else {
+		for _, m := range p.moves {
+			if err := p.moveDir(tx, m, m.from); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Step 5: commit the new contents, temp files moved along with their directories
+	for i, f := range p.files {
+		if err := os.Rename(p.movedPath(temps[i]), f.newPath); err != nil {
+			return fmt.Errorf("failed to write %s: %v", f.newPath, err)
+		}
+		tx.onUndo(func() error {
+			return os.WriteFile(f.newPath, f.before, f.mode)
+		})
+	}
+
+	// Step 6: remove the source directories a merge left empty
+	for _, m := range p.moves {
+		if !m.merge {
+			continue
+		}
+		dir := filepath.Dir(m.from)
+		info, err := os.Stat(dir)
+		if err != nil {
+			continue
+		}
+		if err := os.Remove(dir); err == nil {
+			tx.onUndo(func() error {
+				return os.Mkdir(dir, info.Mode().Perm())
+			})
+		}
+	}
+
+	return nil
+}
+
+// moveDir moves the directory at from to m.to, setting a replaced target aside first
+func (p *renamePlan) moveDir(tx *transaction, m dirMove, from string) error {
+	// Step 2: set a replaced target directory aside, it is only deleted on commit
+	if m.replace {
+		p.logf("Target directory %s exists, removing it (--force enabled)...\n", m.to)
+		backup := backupPath(m.to)
+		if err := os.Rename(m.to, backup); err != nil {
+			return fmt.Errorf("failed to remove target directory: %v", err)
+		}
+		tx.onUndo(func() error {
+			return os.Rename(backup, m.to)
+		})
+		tx.onCommit = append(tx.onCommit, func() error {
+			return os.RemoveAll(backup)
+		})
+	}
+
+	// Step 3: ensure parent directories exist for the target path
+	if err := mkdirAllTx(tx, filepath.Dir(m.to)); err != nil {
+		return fmt.Errorf("failed to create parent directories for %s: %v", m.to, err)
+	}
+
+	// Step 4: rename folder
+	if err := os.Rename(from, m.to); err != nil {
+		return fmt.Errorf("failed to rename folder: %v", err)
+	}
+	tx.onUndo(func() error {
+		return os.Rename(m.to, from)
+	})
+	return nil
+}
+
+// stageMoves moves every directory into a staging directory first and then to its target,
+// so the moves can swap or rotate directories. Nested directories leave before their parents
+// and targets are placed parents first, which puts every file where the innermost move containing
+// it sends it. The empty staging directory is removed on commit.
+func (p *renamePlan) stageMoves(tx *transaction) error {
+	stateDir := p.path(StateDir)
+	if err := mkdirAllTx(tx, stateDir); err != nil {
+		return fmt.Errorf("failed to create staging directory: %v", err)
+	}
+	staging, err := os.MkdirTemp(stateDir, "staging-*")
+	if err != nil {
+		return fmt.Errorf("failed to create staging directory: %v", err)
+	}
+	tx.onUndo(func() error {
+		return removeIfExists(staging)
+	})
+	tx.onCommit = append(tx.onCommit, func() error {
+		return os.Remove(staging)
+	})
+
+	temps := make([]string, len(p.moves))
+	for _, i := range byDepth(p.moves, func(m dirMove) string { return m.from }, true) {
+		from, tmp := p.moves[i].from, filepath.Join(staging, strconv.Itoa(i))
+		if err := os.Rename(from, tmp); err != nil {
+			return fmt.Errorf("failed to rename folder: %v", err)
+		}
+		tx.onUndo(func() error {
+			return os.Rename(tmp, from)
+		})
+		temps[i] = tmp
+	}
+	for _, i := range byDepth(p.moves, func(m dirMove) string { return m.to }, false) {
+		if err := p.moveDir(tx, p.moves[i], temps[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// byDepth returns the indexes of moves ordered by the depth of dir, deepest first if deepest is set
+func byDepth(moves []dirMove, dir func(dirMove) string, deepest bool) []int {
+	depth := func(i int) int {
+		return strings.Count(filepath.Clean(dir(moves[i])), string(filepath.Separator))
+	}
+	order := make([]int, len(moves))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		if deepest {
+			return depth(order[a]) > depth(order[b])
+		}
+		return depth(order[a]) < depth(order[b])
+	})
+	return order
+}
+
+// writeTemp writes data to a hidden temp file next to path and returns its name
+func writeTemp(path string, data []byte, mode fs.FileMode) (string, error) {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".renamepkg-*")
+	if err != nil {
+		return "", err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	if err := os.Chmod(f.Name(), mode); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// mkdirAllTx creates dir and any missing parents, removing the created ones on rollback
+func mkdirAllTx(tx *transaction, dir string) error {
+	var missing []string
+	for d := dir; ; d = filepath.Dir(d) {
+		if _, err := os.Stat(d); err == nil {
+			break
+		}
+		missing = append(missing, d)
+		if filepath.Dir(d) == d {
+			break
+		}
+	}
+
+	for i := len(missing) - 1; i >= 0; i-- {
+		d := missing[i]
+		if err := os.Mkdir(d, 0755); err != nil {
+			return err
+		}
+		tx.onUndo(func() error {
+			return os.Remove(d)
+		})
+	}
+	return nil
+}
+
+// removeIfExists removes path, ignoring files that are already gone
+func removeIfExists(path string) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,193 @@
+package rename
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeTree creates files relative to the current directory
+func writeTree(t *testing.T, files map[string]string) {
+	t.Helper()
+	for path, content := range files {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// readTree returns the regular files under the current directory
+func readTree(t *testing.T) map[string]string {
+	t.Helper()
+	files := make(map[string]string)
+	err := filepath.WalkDir(".", func(path string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		files[filepath.ToSlash(path)] = string(data)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestPlanApplyCommit(t *testing.T) {
+	t.Chdir(t.TempDir())
+	writeTree(t, map[string]string{
+		"go.mod":       "module example.com/app\n",
+		"old/a.go":     "package old\n",
+		"old/b.go":     "package old\n\nfunc B() {}\n",
+		"target/x.go":  "package target\n",
+		"cmd/main.go":  "package main\n\nimport \"example.com/app/old\"\n",
+		"cmd/other.go": "package main\n",
+	})
+
+	plan := &renamePlan{
+		moves: []dirMove{{from: "old", to: "nested/target", replace: false}},
+	}
+	plan.files = []fileChange{
+		{path: "old/a.go", newPath: plan.movedPath("old/a.go"), mode: 0644, before: []byte("package old\n"), after: []byte("package target\n")},
+		{path: "cmd/main.go", newPath: "cmd/main.go", mode: 0644, before: []byte("package main\n\nimport \"example.com/app/old\"\n"), after: []byte("package main\n\nimport \"example.com/app/nested/target\"\n")},
+	}
+
+	tx, err := plan.apply()
+	if err != nil {
+		t.Fatalf("apply() error = %v", err)
+	}
+	if err := tx.commit(); err != nil {
+		t.Fatalf("commit() error = %v", err)
+	}
+
+	expected := map[string]string{
+		"go.mod":             "module example.com/app\n",
+		"nested/target/a.go": "package target\n",
+		"nested/target/b.go": "package old\n\nfunc B() {}\n",
+		"target/x.go":        "package target\n",
+		"cmd/main.go":        "package main\n\nimport \"example.com/app/nested/target\"\n",
+		"cmd/other.go":       "package main\n",
+	}
+	assertTree(t, expected)
+}
+
+func TestPlanApplyRollback(t *testing.T) {
+	t.Chdir(t.TempDir())
+	original := map[string]string{
+		"go.mod":      "module example.com/app\n",
+		"old/a.go":    "package old\n",
+		"target/x.go": "package target\n",
+		"cmd/main.go": "package main\n",
+	}
+	writeTree(t, original)
+	// A directory where a file is expected makes the final rename fail
+	if err := os.MkdirAll("cmd/blocked.go", 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	plan := &renamePlan{
+		moves: []dirMove{{from: "old", to: "target", replace: true}},
+		files: []fileChange{
+			{path: "old/a.go", newPath: "target/a.go", mode: 0644, before: []byte("package old\n"), after: []byte("package target\n")},
+			{path: "go.mod", newPath: "go.mod", mode: 0644, before: []byte("module example.com/app\n"), after: []byte("module example.com/new\n")},
+			{path: "cmd/main.go", newPath: "cmd/blocked.go", mode: 0644, before: []byte("package main\n"), after: []byte("package main\n\n")},
+		},
+	}
+
+	if _, err := plan.apply(); err == nil {
+		t.Fatal("apply() expected error")
+	}
+	assertTree(t, original)
+	if _, err := os.Stat("cmd/blocked.go"); err != nil {
+		t.Errorf("blocking directory should be left alone: %v", err)
+	}
+}
+
+func TestTransactionRollbackAfterApply(t *testing.T) {
+	t.Chdir(t.TempDir())
+	original := map[string]string{
+		"go.mod":   "module example.com/app\n",
+		"old/a.go": "package old\n",
+	}
+	writeTree(t, original)
+
+	plan := &renamePlan{
+		moves: []dirMove{{from: "old", to: "a/b/new"}},
+		files: []fileChange{
+			{path: "old/a.go", newPath: "a/b/new/a.go", mode: 0644, before: []byte("package old\n"), after: []byte("package new\n")},
+			{path: "go.mod", newPath: "go.mod", mode: 0644, before: []byte("module example.com/app\n"), after: []byte("module example.com/new\n")},
+		},
+	}
+
+	tx, err := plan.apply()
+	if err != nil {
+		t.Fatalf("apply() error = %v", err)
+	}
+	if err := tx.rollback(); err != nil {
+		t.Fatalf("rollback() error = %v", err)
+	}
+	assertTree(t, original)
+	if _, err := os.Stat("a"); !os.IsNotExist(err) {
+		t.Errorf("created parent directories should be removed, stat err = %v", err)
+	}
+}
+
+func TestPlanRewritesKeepsOrder(t *testing.T) {
+	t.Chdir(t.TempDir())
+	var files []goFile
+	for i := range 200 {
+		path := fmt.Sprintf("f%03d.go", i)
+		content := "package a\n"
+		if i%3 == 0 {
+			content = "package old\n"
+		}
+		writeTree(t, map[string]string{path: content})
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, goFile{path: path, info: info})
+	}
+
+	plan := &renamePlan{}
+	err := plan.planRewrites(t.Context(), files, func(path string, data []byte) (rewriteResult, error) {
+		return rewriteResult{after: bytes.Replace(data, []byte("old"), []byte("new"), 1)}, nil
+	})
+	if err != nil {
+		t.Fatalf("planRewrites() error = %v", err)
+	}
+
+	if plan.filesProcessed != len(files) {
+		t.Errorf("filesProcessed = %d, want %d", plan.filesProcessed, len(files))
+	}
+	if len(plan.files) != 67 {
+		t.Fatalf("planned %d files, want 67", len(plan.files))
+	}
+	for i, f := range plan.files {
+		if want := fmt.Sprintf("f%03d.go", i*3); f.path != want || string(f.after) != "package new\n" {
+			t.Errorf("files[%d] = %s %q, want %s in walk order", i, f.path, f.after, want)
+		}
+	}
+}
+
+// assertTree checks that the current directory contains exactly the expected files
+func assertTree(t *testing.T, expected map[string]string) {
+	t.Helper()
+	files := readTree(t)
+	for path, content := range expected {
+		if files[path] != content {
+			t.Errorf("%s = %q, want %q", path, files[path], content)
+		}
+	}
+	for path := range files {
+		if _, ok := expected[path]; !ok {
+			t.Errorf("unexpected file %s", path)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package rename
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// rewriteQualifiedRefs rewrites selectors like oldName.Foo to newName.Foo in files that
+// import importPath without an explicit alias.
+// Identifiers are resolved with the parser's scope information, so locals, parameters
+// and fields that happen to be called oldName are left alone.
+// It returns an error if newName is already used as an identifier in the file.
+func rewriteQualifiedRefs(src, importPath, oldName, newName string) (string, error) {
+	return renameQualifiers(src, []qualifierRename{{importPath: importPath, oldName: oldName, newName: newName}})
+}
+
+// qualifierRename is the package name of an import changing from oldName to newName
+// A named rename targets the import spec explicitly named oldName instead of the unnamed one.
+type qualifierRename struct {
+	importPath       string
+	oldName, newName string
+	named            bool
+}
+
+// renameQualifiers rewrites the qualified references of several imports at once, like
+// rewriteQualifiedRefs. A new name may be the old name of another renamed import, so
+// packages can swap or rotate their names. A new name may also be the name of another import
+// of the same path, whose references then stay as they are.
+func renameQualifiers(src string, renames []qualifierRename) (string, error) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "", src, 0)
+	if err != nil {
+		return src, err
+	}
+
+	renamed := make(map[*ast.ImportSpec]bool)
+	newNames := make(map[string]string)
+	var active []qualifierRename
+	for _, r := range renames {
+		for _, imp := range file.Imports {
+			// Explicitly aliased imports keep their alias, so their references stay valid
+			if p, err := strconv.Unquote(imp.Path.Value); err != nil || p != r.importPath {
+				continue
+			}
+			if (!r.named && imp.Name == nil) || (r.named && imp.Name != nil && imp.Name.Name == r.oldName) {
+				renamed[imp] = true
+				newNames[r.oldName] = r.newName
+				active = append(active, r)
+				break
+			}
+		}
+	}
+	if len(active) == 0 {
+		return src, nil
+	}
+
+	// Another import of a renamed path already using the new name is shared, not a conflict,
+	// as when two imports of a merged package collapse into one. The unnamed one is taken to
+	// use the new name.
+	shared := make(map[string]bool)
+	for _, imp := range file.Imports {
+		if renamed[imp] {
+			continue
+		}
+		for _, r := range active {
+			if p, err := strconv.Unquote(imp.Path.Value); err == nil && p == r.importPath && (imp.Name == nil || imp.Name.Name == r.newName) {
+				renamed[imp] = true
+				shared[r.newName] = true
+			}
+		}
+	}
+
+	// A package qualifier is never resolved to a local object
+	qualifiers := make(map[*ast.Ident]bool)
+	ignored := make(map[*ast.Ident]bool)
+	ast.Inspect(file, func(n ast.Node) bool {
+		sel, ok := n.(*ast.SelectorExpr)
+		if !ok {
+			return true
+		}
+		if ident, ok := sel.X.(*ast.Ident); ok && ident.Obj == nil {
+			if newNames[ident.Name] != "" {
+				qualifiers[ident] = true
+				ignored[ident] = true
+			} else if shared[ident.Name] {
+				ignored[ident] = true
+			}
+		}
+		return true
+	})
+
+	taken := make(map[string]string)
+	for _, r := range active {
+		if other, ok := taken[r.newName]; ok {
+			return src, fmt.Errorf("identifier %q already used by package %s", r.newName, other)
+		}
+		taken[r.newName] = r.oldName
+		if conflict := findIdentConflict(fset, file, renamed, ignored, r.newName); conflict != "" {
+			return src, fmt.Errorf("identifier %q already used by %s", r.newName, conflict)
+		}
+	}
+
+	var edits []edit
+	for ident := range qualifiers {
+		start := fset.Position(ident.Pos()).Offset
+		edits = append(edits, edit{start: start, end: start + len(ident.Name), text: newNames[ident.Name]})
+	}
+	return string(applyEdits([]byte(src), edits)), nil
+}
+
+// findIdentConflict reports what already uses name in file, ignoring the import specs being
+// renamed and the qualifiers in ignored
+func findIdentConflict(fset *token.FileSet, file *ast.File, renamed map[*ast.ImportSpec]bool, ignored map[*ast.Ident]bool, name string) string {
+	for _, imp := range file.Imports {
+		if renamed[imp] {
+			continue
+		}
+		if imp.Name != nil {
+			if imp.Name.Name == name {
+				return "import " + imp.Path.Value
+			}
+			continue
+		}
+		if p, err := strconv.Unquote(imp.Path.Value); err == nil && path.Base(p) == name {
+			return "import " + imp.Path.Value
+		}
+	}
+
+	conflict := ""
+	ast.Inspect(file, func(n ast.Node) bool {
+		if imp, ok := n.(*ast.ImportSpec); conflict != "" || ok && renamed[imp] {
+			return false
+		}
+		if ident, ok := n.(*ast.Ident); ok && ident.Name == name && ident != file.Name && !ignored[ident] {
+			conflict = fmt.Sprintf("identifier on line %d", fset.Position(ident.Pos()).Line)
+		}
+		return true
+	})
+	return conflict
+}
+
+// packageScopeConflict reports a package-level declaration of one of names in another file of
+// the package of the file at path, its qualifiers would clash with it. src is the planned
+// content of the file, the rest of the package is read from disk.
+func packageScopeConflict(path, src string, names ...string) string {
+	file, err := parser.ParseFile(token.NewFileSet(), path, src, parser.PackageClauseOnly)
+	if err != nil {
+		return ""
+	}
+	external := strings.HasSuffix(file.Name.Name, "_test") && strings.HasSuffix(path, "_test.go")
+	fset := token.NewFileSet()
+	for _, d := range packageDecls(fset, filepath.Dir(path))[external] {
+		for _, name := range names {
+			if d.name == name && d.pos.Filename != path {
+				return fmt.Sprintf("identifier %q already declared in %s:%d", name, d.pos.Filename, d.pos.Line)
+			}
+		}
+	}
+	return ""
+}
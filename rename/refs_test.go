@@ -0,0 +1,173 @@
+package rename
+
+import "testing"
+
+func TestRewriteQualifiedRefs(t *testing.T) {
+	importPath := "github.com/pillar/chrop/internal/server/difish"
+
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+		wantErr  bool
+	}{
+		{
+			name: "rewrites package selectors",
+			input: `package main
+
+import "github.com/pillar/chrop/internal/server/difish"
+
+func main() {
+	c := di.New()
+	var _ di.Container = c
+}`,
+			expected: `package main
+
+import "github.com/pillar/chrop/internal/server/difish"
+
+func main() {
+	c := difish.New()
+	var _ difish.Container = c
+}`,
+		},
+		{
+			name: "leaves locals, params and fields alone",
+			input: `package main
+
+import "github.com/pillar/chrop/internal/server/difish"
+
+type server struct {
+	di *di.Container
+}
+
+func run(s server) {
+	s.di.Start()
+	di := s.di
+	di.Stop()
+}
+
+func inject(di *di.Container) {
+	di.Start()
+}`,
+			expected: `package main
+
+import "github.com/pillar/chrop/internal/server/difish"
+
+type server struct {
+	di *difish.Container
+}
+
+func run(s server) {
+	s.di.Start()
+	di := s.di
+	di.Stop()
+}
+
+func inject(di *difish.Container) {
+	di.Start()
+}`,
+		},
+		{
+			name: "explicit alias is kept",
+			input: `package main
+
+import inj "github.com/pillar/chrop/internal/server/difish"
+
+func main() {
+	inj.New()
+}`,
+			expected: `package main
+
+import inj "github.com/pillar/chrop/internal/server/difish"
+
+func main() {
+	inj.New()
+}`,
+		},
+		{
+			name: "conflicting identifier",
+			input: `package main
+
+import "github.com/pillar/chrop/internal/server/difish"
+
+func main() {
+	difish := 1
+	_ = di.New(difish)
+}`,
+			wantErr: true,
+		},
+		{
+			name: "conflicting import",
+			input: `package main
+
+import (
+	"github.com/pillar/chrop/internal/server/difish"
+	"github.com/other/difish"
+)
+
+func main() {
+	di.New()
+}`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := rewriteQualifiedRefs(tt.input, importPath, "di", "difish")
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("rewriteQualifiedRefs() expected error, got \n%v", result)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("rewriteQualifiedRefs() error = %v", err)
+			}
+			if normalize(result) != normalize(tt.expected) {
+				t.Errorf("rewriteQualifiedRefs() = \n%v\n, want \n%v", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestRenameQualifiersSwap(t *testing.T) {
+	src := `package main
+
+import (
+	"example.com/app/a"
+	"example.com/app/b"
+)
+
+func main() {
+	a.A()
+	b.B()
+}`
+	swap := []qualifierRename{
+		{importPath: "example.com/app/a", oldName: "b", newName: "a"},
+		{importPath: "example.com/app/b", oldName: "a", newName: "b"},
+	}
+	want := `package main
+
+import (
+	"example.com/app/a"
+	"example.com/app/b"
+)
+
+func main() {
+	b.A()
+	a.B()
+}`
+	got, err := renameQualifiers(src, swap)
+	if err != nil {
+		t.Fatalf("renameQualifiers() error = %v", err)
+	}
+	if got != want {
+		t.Errorf("renameQualifiers() = \n%v\n, want \n%v", got, want)
+	}
+
+	// One at a time the first rename would clash with the other package
+	if _, err := rewriteQualifiedRefs(src, "example.com/app/a", "a", "b"); err == nil {
+		t.Error("rewriteQualifiedRefs() expected error for a name taken by another import")
+	}
+}
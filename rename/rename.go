@@ -0,0 +1,327 @@
+// Package rename renames Go packages and modules: it moves the package directory and rewrites
+// the package clauses, every import of it and the go.mod and go.work files that refer to it.
+//
+// A rename is computed first with Plan, which only reads the filesystem, and applied with Apply
+// as a transaction that is rolled back if any step fails. Paths are relative to the module root
+// in Options.Dir, the working directory by default.
+package rename
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Options describes a rename
+// Set Module to rename the module, From and To to rename a package, or Moves to move many
+// packages at once, together with the module if Module is set too
+type Options struct {
+	// Dir is the module root the paths of the options are relative to, the working directory if empty
+	// The paths of the planned changes start with Dir.
+	Dir string
+	// Module is the new module path, the old one is read from go.mod
+	Module string
+	// From and To are the old and new package directories, relative to the module root
+	From string
+	To   string
+	// Moves are package moves validated and applied together in a single pass
+	Moves []Move
+	// ModulePath is the module path of the package rename, read from go.mod if empty
+	ModulePath string
+
+	// Merge moves the files of a package rename into the target directory if it exists, the files
+	// take the target's package name and importers of both packages keep a single import
+	Merge bool
+	// Force replaces the target directory of a package move if it exists
+	Force bool
+	// RewriteRefs drops the alias importers would get for a changed package name and rewrites
+	// the qualified references instead
+	RewriteRefs bool
+	// NestedModules also rewrites modules nested in subdirectories, each against its own go.mod
+	NestedModules bool
+	// Gofmt formats every changed file as a whole instead of only the edited declarations
+	Gofmt bool
+	// Exclude and Include are globs of files and directories to skip or to process even if skipped
+	Exclude []string
+	Include []string
+
+	// Git stages the applied rename with git, Commit also commits it. Apply then refuses to run
+	// if a tracked file has uncommitted changes, unless AllowDirty is set.
+	Git    bool
+	Commit bool
+	// Verify runs go build and go vet after applying and rolls the rename back if they fail
+	Verify bool
+	// AllowDirty applies the rename even if the touched files have uncommitted changes
+	AllowDirty bool
+
+	// Command describes the rename in its undo journal, the commit subject if empty
+	Command []string
+
+	// Output receives progress messages and warnings, nil discards them
+	Output io.Writer
+	// Events, if set, receives every import, move, file and go.mod change, warning and conflict
+	// as soon as it is planned, and the warnings of applying the rename. It is called from the
+	// goroutine running Plan or Apply.
+	Events func(Event)
+}
+
+// ImportRename is an import path changed by the rename
+// Alias is the name importers keep for the package when its declared name changes
+type ImportRename struct {
+	Old   string `json:"old"`
+	New   string `json:"new"`
+	Alias string `json:"alias,omitempty"`
+}
+
+// DirMove is a directory moved by the rename
+// Replace is set when the target exists and is removed first, Merge when From is a file or
+// directory merged into the existing target package
+type DirMove struct {
+	From    string
+	To      string
+	Replace bool
+	Merge   bool
+}
+
+// FileEdit is a file rewritten by the rename
+// Path is where the file lives before the rename, NewPath where it ends up after the directory moves
+type FileEdit struct {
+	Path    string
+	NewPath string
+	Mode    fs.FileMode
+	Before  []byte
+	After   []byte
+}
+
+// Changes is a planned rename, nothing on disk changes until it is applied
+type Changes struct {
+	plan *renamePlan
+	opts Options
+	git  *gitOptions
+}
+
+// Imports returns the import paths changed by the rename
+func (c *Changes) Imports() []ImportRename {
+	return slices.Clone(c.plan.imports)
+}
+
+// Moves returns the directories moved by the rename
+func (c *Changes) Moves() []DirMove {
+	var moves []DirMove
+	for _, m := range c.plan.moves {
+		moves = append(moves, DirMove{From: m.from, To: m.to, Replace: m.replace, Merge: m.merge})
+	}
+	return moves
+}
+
+// Files returns the rewritten files, except go.mod and go.work files
+func (c *Changes) Files() []FileEdit {
+	return c.fileEdits(false)
+}
+
+// GoMod returns the rewritten go.mod and go.work files
+func (c *Changes) GoMod() []FileEdit {
+	return c.fileEdits(true)
+}
+
+// fileEdits returns copies of the planned file edits, of go.mod and go.work files or of the others
+func (c *Changes) fileEdits(goMod bool) []FileEdit {
+	var edits []FileEdit
+	for _, f := range c.plan.files {
+		switch filepath.Base(f.path) {
+		case "go.mod", "go.work":
+			if !goMod {
+				continue
+			}
+		default:
+			if goMod {
+				continue
+			}
+		}
+		edits = append(edits, FileEdit{
+			Path:    f.path,
+			NewPath: f.newPath,
+			Mode:    f.mode,
+			Before:  bytes.Clone(f.before),
+			After:   bytes.Clone(f.after),
+		})
+	}
+	return edits
+}
+
+// Conflicts returns the problems that make the rename unsafe, Apply refuses to run if there are any
+func (c *Changes) Conflicts() []string {
+	return slices.Clone(c.plan.conflicts)
+}
+
+// Warnings returns the warnings of planning the rename
+func (c *Changes) Warnings() []string {
+	return slices.Clone(c.plan.warnings)
+}
+
+// FilesProcessed returns the number of Go files the rename read
+func (c *Changes) FilesProcessed() int {
+	return c.plan.filesProcessed
+}
+
+// Plan computes the rename described by opts without touching the filesystem
+func Plan(ctx context.Context, opts Options) (*Changes, error) {
+	plan := &renamePlan{dir: opts.Dir, command: opts.Command, out: opts.Output, events: opts.Events}
+	filter, err := newWalkFilter(plan.path("."), opts.Exclude, opts.Include)
+	if err != nil {
+		return nil, err
+	}
+
+	var git *gitOptions
+	if opts.Git || opts.Commit {
+		git = &gitOptions{commit: opts.Commit}
+	}
+
+	var subject string
+	switch {
+	case len(opts.Moves) > 0:
+		if opts.From != "" || opts.To != "" {
+			return nil, errors.New("cannot combine Moves with From and To")
+		}
+		if err := planBatchRename(ctx, plan, opts, filter); err != nil {
+			return nil, err
+		}
+		subject = fmt.Sprintf("Move %d packages", len(opts.Moves))
+		if opts.Module != "" {
+			subject = fmt.Sprintf("Rename module %s to %s and move %d packages", plan.imports[0].Old, plan.imports[0].New, len(opts.Moves))
+		}
+	case opts.Module != "":
+		if opts.From != "" || opts.To != "" {
+			return nil, errors.New("cannot rename a module and a package at once")
+		}
+		oldModule, err := readModuleFromGoMod(plan.path("."))
+		if err != nil {
+			return nil, err
+		}
+		oldModule, newModule := filepath.ToSlash(oldModule), filepath.ToSlash(opts.Module)
+		subject = fmt.Sprintf("Rename module %s to %s", oldModule, newModule)
+		plan.logf("Rename module imports:\n")
+		plan.logf("   %s → %s\n", oldModule, newModule)
+		plan.addImports(
+			ImportRename{Old: oldModule, New: newModule},
+			ImportRename{Old: oldModule + "/...", New: newModule + "/..."})
+		if _, err := planModuleRename(ctx, plan, opts, filter, oldModule, nil); err != nil {
+			return nil, err
+		}
+	case opts.From != "" && opts.To != "":
+		if err := planPackageRename(ctx, plan, opts, filter); err != nil {
+			return nil, err
+		}
+		subject = fmt.Sprintf("Rename package %s to %s", plan.imports[0].Old, plan.imports[0].New)
+		if len(plan.moves) > 0 && plan.moves[0].merge {
+			subject = fmt.Sprintf("Merge package %s into %s", plan.imports[0].Old, plan.imports[0].New)
+		}
+	default:
+		return nil, errors.New("either Module, From and To or Moves are required")
+	}
+	if git != nil {
+		git.message = commitMessage(subject, plan.imports)
+	}
+	if len(plan.command) == 0 {
+		plan.command = []string{subject}
+	}
+	return &Changes{plan: plan, opts: opts, git: git}, nil
+}
+
+// Err returns an error listing the conflicts of the planned rename, or nil if there are none
+func (c *Changes) Err() error {
+	return c.plan.conflictErr()
+}
+
+// WriteDiff writes the directory moves and a unified diff of every changed file to w
+func (c *Changes) WriteDiff(w io.Writer) {
+	c.plan.writeDiff(w)
+}
+
+// Apply applies the planned rename as a transaction and records it for Undo
+// Progress messages go to the Output of the options it was planned with.
+func Apply(c *Changes) error {
+	return runPlan(c.plan, runOptions{allowDirty: c.opts.AllowDirty, verify: c.opts.Verify, git: c.git})
+}
+
+// Undo reverts the last applied rename of the module in dir, the working directory if empty
+// Warnings are written to out. It returns the journal of the reverted rename.
+func Undo(dir string, out io.Writer) (*Journal, error) {
+	return undoLast(dir, out)
+}
+
+// runOptions controls how runPlan applies a plan
+type runOptions struct {
+	allowDirty bool
+	verify     bool
+	git        *gitOptions
+}
+
+// runPlan applies plan and reports the updated files
+// Files with uncommitted changes are refused unless allowDirty is set, and with git so is any
+// uncommitted change to a tracked file
+// With verify the applied plan is rolled back if the touched modules no longer build or vet cleanly
+// With git set the applied changes are staged (and committed) before the transaction is committed
+func runPlan(plan *renamePlan, opts runOptions) error {
+	if err := plan.conflictErr(); err != nil {
+		return err
+	}
+	if !opts.allowDirty {
+		// The working tree must be clean so the staged changes are only the rename
+		if opts.git != nil {
+			if err := gitCheckClean(plan.path(".")); err != nil {
+				return err
+			}
+		}
+		if err := checkDirty(plan); err != nil {
+			return err
+		}
+	}
+	tx, err := plan.apply()
+	if err != nil {
+		return err
+	}
+	if opts.verify {
+		plan.logf("Verifying with go build and go vet...\n")
+		if err := verifyBuild(plan); err != nil {
+			if rbErr := tx.rollback(); rbErr != nil {
+				return fmt.Errorf("%v\n%v", err, rbErr)
+			}
+			return fmt.Errorf("%v\nThe rename was rolled back.", err)
+		}
+	}
+	if err := recordJournal(tx, plan); err != nil {
+		if rbErr := tx.rollback(); rbErr != nil {
+			return fmt.Errorf("failed to record undo journal: %v (%v)", err, rbErr)
+		}
+		return fmt.Errorf("failed to record undo journal: %v", err)
+	}
+	if opts.git != nil {
+		if err := gitStage(tx, plan, opts.git); err != nil {
+			if rbErr := tx.rollback(); rbErr != nil {
+				return fmt.Errorf("%v (%v)", err, rbErr)
+			}
+			return err
+		}
+	}
+	if err := tx.commit(); err != nil {
+		plan.warnf("failed to clean up: %v", err)
+	}
+
+	for _, f := range plan.files {
+		plan.logf("  Updated: %s\n", f.newPath)
+	}
+	if opts.git != nil && opts.git.commit {
+		plan.logf("  Committed: %s\n", strings.SplitN(opts.git.message, "\n", 2)[0])
+	} else if opts.git != nil {
+		plan.logf("  Staged the changes with git\n")
+	}
+	plan.logf("\nCompleted successfully. Processed %d files, modified %d files.\n", plan.filesProcessed, len(plan.files))
+	return nil
+}
@@ -0,0 +1,278 @@
+package rename
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPlanApplyUndo(t *testing.T) {
+	t.Chdir(t.TempDir())
+	original := map[string]string{
+		"go.mod":      "module example.com/app\n",
+		"old/a.go":    "package old\n\nfunc A() {}\n",
+		"cmd/main.go": "package main\n\nimport \"example.com/app/old\"\n\nfunc main() { old.A() }\n",
+	}
+	writeTree(t, original)
+
+	changes, err := Plan(t.Context(), Options{From: "old", To: "pkg/renamed", AllowDirty: true})
+	if err != nil {
+		t.Fatalf("Plan() error = %v", err)
+	}
+	if len(changes.Imports()) != 1 || changes.Imports()[0] != (ImportRename{Old: "example.com/app/old", New: "example.com/app/pkg/renamed", Alias: "old"}) {
+		t.Errorf("Imports = %+v", changes.Imports())
+	}
+	if len(changes.Moves()) != 1 || changes.Moves()[0] != (DirMove{From: "old", To: "pkg/renamed"}) {
+		t.Errorf("Moves = %+v", changes.Moves())
+	}
+	if len(changes.Files()) != 2 || len(changes.GoMod()) != 0 {
+		t.Errorf("Files = %d, GoMod = %d, want 2 and 0", len(changes.Files()), len(changes.GoMod()))
+	}
+	// Planning does not touch the filesystem
+	if _, err := os.Stat("pkg"); err == nil {
+		t.Fatal("Plan() moved the package")
+	}
+
+	if err := Apply(changes); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	data, err := os.ReadFile("cmd/main.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "import old \"example.com/app/pkg/renamed\""; !strings.Contains(string(data), want) {
+		t.Errorf("cmd/main.go = %q, want %q", data, want)
+	}
+
+	if _, err := Undo("", nil); err != nil {
+		t.Fatalf("Undo() error = %v", err)
+	}
+	if err := os.RemoveAll(StateDir); err != nil {
+		t.Fatal(err)
+	}
+	assertTree(t, original)
+}
+
+func TestPlanApplyUndoInDir(t *testing.T) {
+	t.Chdir(t.TempDir())
+	original := map[string]string{
+		"proj/go.mod":      "module example.com/app\n",
+		"proj/old/a.go":    "package old\n\nfunc A() {}\n",
+		"proj/cmd/main.go": "package main\n\nimport \"example.com/app/old\"\n\nfunc main() { old.A() }\n",
+	}
+	writeTree(t, original)
+
+	changes, err := Plan(t.Context(), Options{Dir: "proj", From: "old", To: "renamed", AllowDirty: true, Command: []string{"test", "rename"}})
+	if err != nil {
+		t.Fatalf("Plan() error = %v", err)
+	}
+	if len(changes.Moves()) != 1 || changes.Moves()[0] != (DirMove{From: filepath.Join("proj", "old"), To: filepath.Join("proj", "renamed")}) {
+		t.Errorf("Moves = %+v", changes.Moves())
+	}
+	if err := Apply(changes); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	data, err := os.ReadFile("proj/cmd/main.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "import old \"example.com/app/renamed\""; !strings.Contains(string(data), want) {
+		t.Errorf("proj/cmd/main.go = %q, want %q", data, want)
+	}
+
+	j, err := Undo("proj", nil)
+	if err != nil {
+		t.Fatalf("Undo() error = %v", err)
+	}
+	if !reflect.DeepEqual(j.Command, []string{"test", "rename"}) || j.Moves[0] != (JournalMove{From: "old", To: "renamed"}) {
+		t.Errorf("Undo() journal = %+v, want the command of the options and paths relative to proj", j)
+	}
+	if err := os.RemoveAll(filepath.Join("proj", StateDir)); err != nil {
+		t.Fatal(err)
+	}
+	assertTree(t, original)
+}
+
+func TestPlanKeepsDeclaredName(t *testing.T) {
+	t.Chdir(t.TempDir())
+	writeTree(t, map[string]string{
+		"go.mod":      "module example.com/app\n",
+		"a/di/di.go":  "package container\n\nfunc New() {}\n",
+		"cmd/main.go": "package main\n\nimport \"example.com/app/a/di\"\n\nfunc main() { container.New() }\n",
+	})
+
+	// The directory keeps its name, so the declared name stays and importers need no alias
+	changes, err := Plan(t.Context(), Options{From: "a/di", To: "b/di"})
+	if err != nil {
+		t.Fatalf("Plan() error = %v", err)
+	}
+	want := map[string]string{
+		"cmd/main.go": "package main\n\nimport \"example.com/app/b/di\"\n\nfunc main() { container.New() }\n",
+	}
+	if len(changes.Files()) != len(want) {
+		t.Errorf("Files = %+v, want only cmd/main.go", changes.Files())
+	}
+	for _, f := range changes.Files() {
+		if string(f.After) != want[f.Path] {
+			t.Errorf("%s = %q, want %q", f.Path, f.After, want[f.Path])
+		}
+	}
+	if len(changes.Imports()) != 1 || changes.Imports()[0].Alias != "" {
+		t.Errorf("Imports = %+v, want no alias", changes.Imports())
+	}
+}
+
+func TestPlanDirectoryOfSubpackages(t *testing.T) {
+	t.Chdir(t.TempDir())
+	writeTree(t, map[string]string{
+		"go.mod":                   "module example.com/app\n",
+		"internal/server/di/di.go": "package di\n",
+		"cmd/main.go":              "package main\n\nimport _ \"example.com/app/internal/server/di\"\n",
+	})
+
+	// internal/server is no package of its own: no package name to read, no alias to keep
+	changes, err := Plan(t.Context(), Options{From: "internal/server", To: "internal/srv"})
+	if err != nil {
+		t.Fatalf("Plan() error = %v", err)
+	}
+	if len(changes.Warnings()) != 0 {
+		t.Errorf("Warnings = %q, want none", changes.Warnings())
+	}
+	want := []ImportRename{
+		{Old: "example.com/app/internal/server", New: "example.com/app/internal/srv"},
+		{Old: "example.com/app/internal/server/...", New: "example.com/app/internal/srv/..."},
+	}
+	if !reflect.DeepEqual(changes.Imports(), want) {
+		t.Errorf("Imports = %+v, want %+v", changes.Imports(), want)
+	}
+}
+
+func TestPlanWarnsAboutUnparsableFiles(t *testing.T) {
+	t.Chdir(t.TempDir())
+	writeTree(t, map[string]string{
+		"go.mod":        "module example.com/app\n",
+		"old/a.go":      "package old\n",
+		"cmd/broken.go": "package main\n\nimport (\n\t\"example.com/app/old\"\n",
+	})
+
+	for _, opts := range []Options{
+		{From: "old", To: "renamed"},
+		{Module: "example.com/renamed"},
+		{Moves: []Move{{From: "old", To: "renamed"}}},
+	} {
+		changes, err := Plan(t.Context(), opts)
+		if err != nil {
+			t.Fatalf("Plan(%+v) error = %v", opts, err)
+		}
+		if len(changes.Warnings()) != 1 || !strings.HasPrefix(changes.Warnings()[0], "skipping cmd/broken.go: ") {
+			t.Errorf("Plan(%+v) Warnings = %q, want cmd/broken.go skipped", opts, changes.Warnings())
+		}
+		for _, f := range changes.Files() {
+			if f.Path == "cmd/broken.go" {
+				t.Errorf("Plan(%+v) rewrote cmd/broken.go", opts)
+			}
+		}
+	}
+}
+
+func TestPlanRewriteRefsPackageScope(t *testing.T) {
+	t.Chdir(t.TempDir())
+	writeTree(t, map[string]string{
+		"go.mod":      "module example.com/app\n",
+		"b/b.go":      "package b\n\nfunc X() {}\n",
+		"c/c.go":      "package c\n\nimport \"example.com/app/b\"\n\nfunc F() { b.X() }\n",
+		"c/other.go":  "package c\n\nvar bb = 1\n",
+		"c/c_test.go": "package c_test\n\nimport \"example.com/app/b\"\n\nfunc G() { b.X() }\n",
+	})
+
+	// The external test package does not see the bb of package c
+	want := []string{"c/c.go: identifier \"bb\" already declared in c/other.go:3, run without --rewrite-refs to keep the alias"}
+	for _, opts := range []Options{
+		{From: "b", To: "bb", RewriteRefs: true},
+		{Moves: []Move{{From: "b", To: "bb"}}, RewriteRefs: true},
+	} {
+		changes, err := Plan(t.Context(), opts)
+		if err != nil {
+			t.Fatalf("Plan(%+v) error = %v", opts, err)
+		}
+		if !reflect.DeepEqual(changes.Conflicts(), want) {
+			t.Errorf("Plan(%+v) Conflicts = %q, want %q", opts, changes.Conflicts(), want)
+		}
+	}
+}
+
+func TestPlanModule(t *testing.T) {
+	t.Chdir(t.TempDir())
+	writeTree(t, map[string]string{
+		"go.mod":      "module example.com/app\n",
+		"cmd/main.go": "package main\n\nimport \"example.com/app/lib\"\n",
+		"lib/lib.go":  "package lib\n",
+	})
+
+	var out strings.Builder
+	changes, err := Plan(t.Context(), Options{Module: "example.com/renamed", Output: &out})
+	if err != nil {
+		t.Fatalf("Plan() error = %v", err)
+	}
+	if len(changes.Files()) != 1 || changes.Files()[0].Path != "cmd/main.go" {
+		t.Errorf("Files = %+v, want cmd/main.go", changes.Files())
+	}
+	if len(changes.GoMod()) != 1 || string(changes.GoMod()[0].After) != "module example.com/renamed\n" {
+		t.Errorf("GoMod = %+v, want the module line renamed", changes.GoMod())
+	}
+	if !strings.Contains(out.String(), "example.com/app → example.com/renamed") {
+		t.Errorf("Output = %q, want the module rename", out.String())
+	}
+}
+
+func TestPlanModuleBrokenGoMod(t *testing.T) {
+	t.Chdir(t.TempDir())
+	writeTree(t, map[string]string{
+		"go.mod":      "module example.com/app\n\nrequire (\n\texample.com/dep v1.0.0\n",
+		"cmd/main.go": "package main\n\nimport \"example.com/app/lib\"\n",
+		"lib/lib.go":  "package lib\n",
+	})
+
+	// The imports are not rewritten against a go.mod that keeps the old module path
+	if _, err := Plan(t.Context(), Options{Module: "example.com/renamed"}); err == nil || !strings.Contains(err.Error(), "go.mod") {
+		t.Errorf("Plan() error = %v, want go.mod failing to update", err)
+	}
+}
+
+func TestPlanErrors(t *testing.T) {
+	t.Chdir(t.TempDir())
+	writeTree(t, map[string]string{
+		"go.mod":   "module example.com/app\n",
+		"old/a.go": "package old\n",
+		"new/b.go": "package new\n",
+	})
+
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	tests := []struct {
+		name string
+		ctx  context.Context
+		opts Options
+		want string
+	}{
+		{"nothing to rename", t.Context(), Options{}, "required"},
+		{"module and package", t.Context(), Options{Module: "example.com/x", From: "old", To: "x"}, "at once"},
+		{"target exists", t.Context(), Options{From: "old", To: "new"}, "already exists"},
+		{"cancelled", ctx, Options{From: "old", To: "x"}, context.Canceled.Error()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Plan(tt.ctx, tt.opts)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Plan() error = %v, want %q", err, tt.want)
+			}
+			if tt.ctx.Err() != nil && !errors.Is(err, context.Canceled) {
+				t.Errorf("Plan() error = %v, want context.Canceled", err)
+			}
+		})
+	}
+}
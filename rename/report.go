@@ -0,0 +1,305 @@
+package rename
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/parser"
+	"go/token"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Report is the machine-readable account of a rename, see Changes.Report
+type Report struct {
+	DryRun  bool           `json:"dryRun"`
+	Applied bool           `json:"applied"`
+	Error   string         `json:"error,omitempty"`
+	Imports []ImportRename `json:"imports"`
+	Moves   []MoveReport   `json:"moves"`
+	Files   []FileReport   `json:"files"`
+	// GoMod lists the go.mod and go.work edits
+	GoMod          []FileReport `json:"goMod"`
+	Warnings       []string     `json:"warnings"`
+	Conflicts      []string     `json:"conflicts"`
+	FilesProcessed int          `json:"filesProcessed"`
+	FilesModified  int          `json:"filesModified"`
+}
+
+// MoveReport is a directory moved by the rename
+type MoveReport struct {
+	From    string `json:"from"`
+	To      string `json:"to"`
+	Replace bool   `json:"replace,omitempty"`
+	Merge   bool   `json:"merge,omitempty"`
+}
+
+// FileReport is a file touched by the rename
+// Go files list their changed import specs and package clause, other files their changed lines
+type FileReport struct {
+	Path    string         `json:"path"`
+	NewPath string         `json:"newPath"`
+	Imports []ImportChange `json:"imports,omitempty"`
+	Package *PackageChange `json:"package,omitempty"`
+	Lines   []LineChange   `json:"lines,omitempty"`
+}
+
+// ImportChange is an import spec rewritten by the rename
+// Alias is set when the new spec introduces an alias the old one did not have
+type ImportChange struct {
+	Old   *ImportSpec `json:"old,omitempty"`
+	New   *ImportSpec `json:"new,omitempty"`
+	Alias string      `json:"alias,omitempty"`
+}
+
+// ImportSpec is an import spec with its line, Name is the alias if any
+type ImportSpec struct {
+	Name string `json:"name,omitempty"`
+	Path string `json:"path"`
+	Line int    `json:"line"`
+}
+
+// PackageChange is a package clause rewritten by the rename
+type PackageChange struct {
+	Old  string `json:"old"`
+	New  string `json:"new"`
+	Line int    `json:"line"`
+}
+
+// LineChange is a changed line of a file other than Go source, the line numbers start at 1
+// A removed line has no New, an added one no Old
+type LineChange struct {
+	OldLine int    `json:"oldLine,omitempty"`
+	NewLine int    `json:"newLine,omitempty"`
+	Old     string `json:"old,omitempty"`
+	New     string `json:"new,omitempty"`
+}
+
+// NewReport returns an empty report, its lists encode as [] rather than null
+// It reports a rename that failed before anything was planned.
+func NewReport() *Report {
+	return &Report{
+		Imports:   []ImportRename{},
+		Moves:     []MoveReport{},
+		Files:     []FileReport{},
+		GoMod:     []FileReport{},
+		Warnings:  []string{},
+		Conflicts: []string{},
+	}
+}
+
+// Report returns the report of the planned rename, including the warnings of applying it so far
+// Applied and Error are left for the caller to fill in
+func (c *Changes) Report() *Report {
+	p := c.plan
+	r := NewReport()
+	r.Imports = append(r.Imports, p.imports...)
+	r.Warnings = append(r.Warnings, p.warnings...)
+	r.Conflicts = append(r.Conflicts, p.conflicts...)
+	r.FilesProcessed = p.filesProcessed
+	r.FilesModified = len(p.files)
+	for _, m := range p.moves {
+		r.Moves = append(r.Moves, moveReport(m))
+	}
+	for _, f := range p.files {
+		if fr, goMod := fileReport(f); goMod {
+			r.GoMod = append(r.GoMod, fr)
+		} else {
+			r.Files = append(r.Files, fr)
+		}
+	}
+	return r
+}
+
+// moveReport returns the report of a planned directory move
+func moveReport(m dirMove) MoveReport {
+	return MoveReport{From: m.from, To: m.to, Replace: m.replace, Merge: m.merge}
+}
+
+// fileReport returns the report of a planned file change and whether it is a go.mod or go.work file
+func fileReport(f fileChange) (FileReport, bool) {
+	fr := FileReport{Path: filepath.ToSlash(f.path), NewPath: filepath.ToSlash(f.newPath)}
+	switch filepath.Base(f.path) {
+	case "go.mod", "go.work":
+		fr.Lines = lineChanges(f.before, f.after)
+		return fr, true
+	}
+	if strings.HasSuffix(f.path, ".go") {
+		fr.Imports, fr.Package = goChanges(f.path, f.before, f.after)
+	} else {
+		fr.Lines = lineChanges(f.before, f.after)
+	}
+	return fr, false
+}
+
+// Summary returns how the reported rename ended
+func (r *Report) Summary() *Summary {
+	return &Summary{DryRun: r.DryRun, Applied: r.Applied, Error: r.Error, FilesProcessed: r.FilesProcessed, FilesModified: r.FilesModified}
+}
+
+// WriteJSON writes the report as a single indented JSON document
+func (r *Report) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// Summary is how a rename ended, the last event of a stream
+type Summary struct {
+	DryRun         bool   `json:"dryRun"`
+	Applied        bool   `json:"applied"`
+	Error          string `json:"error,omitempty"`
+	FilesProcessed int    `json:"filesProcessed"`
+	FilesModified  int    `json:"filesModified"`
+}
+
+// Event is a step of a rename, passed to Options.Events as soon as it is planned or applied
+// Type tells which field is set: import (Import), move (Move), file and gomod (File), warning and
+// conflict (Message). Plan and Apply never send the summary event (Summary), it is left to the
+// caller, which knows how the rename ended.
+type Event struct {
+	Type    string
+	Import  *ImportRename
+	Move    *MoveReport
+	File    *FileReport
+	Message string
+	Summary *Summary
+}
+
+// MarshalJSON encodes the event as a single object, its type first and then the fields of what it reports
+func (e Event) MarshalJSON() ([]byte, error) {
+	var v any
+	switch {
+	case e.Import != nil:
+		v = e.Import
+	case e.Move != nil:
+		v = e.Move
+	case e.File != nil:
+		v = e.File
+	case e.Summary != nil:
+		v = e.Summary
+	default:
+		v = struct {
+			Message string `json:"message"`
+		}{e.Message}
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	out := fmt.Appendf(nil, "{\"type\":%q", e.Type)
+	if len(data) > 2 {
+		out = append(out, ',')
+	}
+	return append(out, data[1:]...), nil
+}
+
+// goChanges returns the import specs and the package clause that differ between before and after
+// Removed and added specs are paired in order, the rename edits them in place
+func goChanges(path string, before, after []byte) ([]ImportChange, *PackageChange) {
+	oldSpecs, oldPkg := parseSpecs(path, before)
+	newSpecs, newPkg := parseSpecs(path, after)
+
+	var removed, added []ImportSpec
+	for _, s := range oldSpecs {
+		if !containsSpec(newSpecs, s) {
+			removed = append(removed, s)
+		}
+	}
+	for _, s := range newSpecs {
+		if !containsSpec(oldSpecs, s) {
+			added = append(added, s)
+		}
+	}
+
+	var changes []ImportChange
+	for i := 0; i < max(len(removed), len(added)); i++ {
+		var ch ImportChange
+		if i < len(removed) {
+			ch.Old = &removed[i]
+		}
+		if i < len(added) {
+			ch.New = &added[i]
+			if added[i].Name != "" && (ch.Old == nil || ch.Old.Name != added[i].Name) {
+				ch.Alias = added[i].Name
+			}
+		}
+		changes = append(changes, ch)
+	}
+
+	var pkg *PackageChange
+	if oldPkg.Name != newPkg.Name {
+		pkg = &PackageChange{Old: oldPkg.Name, New: newPkg.Name, Line: newPkg.Line}
+	}
+	return changes, pkg
+}
+
+// parseSpecs returns the import specs of src and its package clause as a spec of the package name
+func parseSpecs(path string, src []byte) ([]ImportSpec, ImportSpec) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, path, src, parser.ImportsOnly)
+	if err != nil {
+		return nil, ImportSpec{}
+	}
+	var specs []ImportSpec
+	for _, spec := range file.Imports {
+		p, err := strconv.Unquote(spec.Path.Value)
+		if err != nil {
+			continue
+		}
+		s := ImportSpec{Path: p, Line: fset.Position(spec.Pos()).Line}
+		if spec.Name != nil {
+			s.Name = spec.Name.Name
+		}
+		specs = append(specs, s)
+	}
+	return specs, ImportSpec{Name: file.Name.Name, Line: fset.Position(file.Package).Line}
+}
+
+// containsSpec reports whether specs has a spec with the name and path of s, lines aside
+func containsSpec(specs []ImportSpec, s ImportSpec) bool {
+	for _, o := range specs {
+		if o.Name == s.Name && o.Path == s.Path {
+			return true
+		}
+	}
+	return false
+}
+
+// lineChanges returns the changed lines between before and after
+// Runs of removed lines followed by added lines are paired up as replaced lines
+func lineChanges(before, after []byte) []LineChange {
+	var changes []LineChange
+	var removed, added []diffOp
+	flush := func() {
+		for i := 0; i < max(len(removed), len(added)); i++ {
+			var ch LineChange
+			if i < len(removed) {
+				ch.OldLine = removed[i].a + 1
+				ch.Old = strings.TrimRight(removed[i].line, "\r\n")
+			}
+			if i < len(added) {
+				ch.NewLine = added[i].b + 1
+				ch.New = strings.TrimRight(added[i].line, "\r\n")
+			}
+			changes = append(changes, ch)
+		}
+		removed, added = nil, nil
+	}
+	for _, op := range diffLines(splitLines(before), splitLines(after)) {
+		switch op.kind {
+		case '-':
+			if len(added) > 0 {
+				flush()
+			}
+			removed = append(removed, op)
+		case '+':
+			added = append(added, op)
+		default:
+			flush()
+		}
+	}
+	flush()
+	return changes
+}
@@ -0,0 +1,122 @@
+package rename
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReport(t *testing.T) {
+	plan := &renamePlan{
+		imports:        []ImportRename{{Old: "example.com/app/old", New: "example.com/app/renamed", Alias: "old"}},
+		moves:          []dirMove{{from: "old", to: "renamed"}},
+		warnings:       []string{"cannot format cmd/broken.go"},
+		filesProcessed: 4,
+	}
+	plan.files = []fileChange{
+		{
+			path:    "old/a.go",
+			newPath: "renamed/a.go",
+			before:  []byte("// Package old\npackage old\n\nimport \"fmt\"\n"),
+			after:   []byte("// Package old\npackage renamed\n\nimport \"fmt\"\n"),
+		},
+		{
+			path:    "cmd/main.go",
+			newPath: "cmd/main.go",
+			before:  []byte("package main\n\nimport (\n\t\"fmt\"\n\t\"example.com/app/old\"\n\tx \"example.com/app/old/sub\"\n)\n"),
+			after:   []byte("package main\n\nimport (\n\t\"fmt\"\n\told \"example.com/app/renamed\"\n\tx \"example.com/app/renamed/sub\"\n)\n"),
+		},
+		{
+			path:    "go.mod",
+			newPath: "go.mod",
+			before:  []byte("module example.com/app\n\ngo 1.22\n\nreplace example.com/app/old => ./old\n"),
+			after:   []byte("module example.com/app\n\ngo 1.22\n\nreplace example.com/app/renamed => ./renamed\n"),
+		},
+	}
+	c := &Changes{plan: plan}
+
+	r := c.Report()
+	if len(r.Files) != 2 || len(r.GoMod) != 1 || r.FilesModified != 3 || r.FilesProcessed != 4 {
+		t.Fatalf("Report() = %+v", r)
+	}
+	if want := (&PackageChange{Old: "old", New: "renamed", Line: 2}); !reflect.DeepEqual(r.Files[0].Package, want) || len(r.Files[0].Imports) != 0 {
+		t.Errorf("Files[0] = %+v, want only the package clause %+v", r.Files[0], want)
+	}
+	wantImports := []ImportChange{
+		{Old: &ImportSpec{Path: "example.com/app/old", Line: 5}, New: &ImportSpec{Name: "old", Path: "example.com/app/renamed", Line: 5}, Alias: "old"},
+		{Old: &ImportSpec{Name: "x", Path: "example.com/app/old/sub", Line: 6}, New: &ImportSpec{Name: "x", Path: "example.com/app/renamed/sub", Line: 6}},
+	}
+	if !reflect.DeepEqual(r.Files[1].Imports, wantImports) {
+		t.Errorf("Files[1].Imports = %+v, want %+v", r.Files[1].Imports, wantImports)
+	}
+	wantLines := []LineChange{{OldLine: 5, NewLine: 5, Old: "replace example.com/app/old => ./old", New: "replace example.com/app/renamed => ./renamed"}}
+	if !reflect.DeepEqual(r.GoMod[0].Lines, wantLines) {
+		t.Errorf("GoMod[0].Lines = %+v, want %+v", r.GoMod[0].Lines, wantLines)
+	}
+
+}
+
+func TestPlanEvents(t *testing.T) {
+	t.Chdir(t.TempDir())
+	writeTree(t, map[string]string{
+		"go.mod":         "module example.com/app\n\ngo 1.22\n\nreplace example.com/app/old => ./old\n",
+		"old/a.go":       "package old\n",
+		"cmd/main.go":    "package main\n\nimport \"example.com/app/old\"\n",
+		"cmd/broken.go":  "package main\n\nimport (\n\t\"example.com/app/old\"\n",
+		"cmd/unused.go":  "package main\n",
+		"other/other.go": "package other\n",
+	})
+
+	var lines []string
+	_, err := Plan(t.Context(), Options{Module: "example.com/renamed", Events: func(e Event) {
+		data, err := json.Marshal(e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, string(data))
+	}})
+	if err != nil {
+		t.Fatalf("Plan() error = %v", err)
+	}
+	want := []string{
+		`{"type":"import","old":"example.com/app","new":"example.com/renamed"}`,
+		`{"type":"import","old":"example.com/app/...","new":"example.com/renamed/..."}`,
+		`{"type":"warning","message":"skipping cmd/broken.go: 4:24: expected ')', found 'EOF'"}`,
+		`{"type":"file","path":"cmd/main.go","newPath":"cmd/main.go","imports":[{"old":{"path":"example.com/app/old","line":3},"new":{"path":"example.com/renamed/old","line":3}}]}`,
+		`{"type":"gomod","path":"go.mod","newPath":"go.mod","lines":[{"oldLine":1,"newLine":1,"old":"module example.com/app","new":"module example.com/renamed"},{"oldLine":5,"newLine":5,"old":"replace example.com/app/old =\u003e ./old","new":"replace example.com/renamed/old =\u003e ./old"}]}`,
+	}
+	if !reflect.DeepEqual(lines, want) {
+		t.Errorf("events =\n%s\nwant\n%s", strings.Join(lines, "\n"), strings.Join(want, "\n"))
+	}
+
+	data, err := json.Marshal(Event{Type: "summary", Summary: &Summary{Applied: true, FilesProcessed: 4}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"type":"summary","dryRun":false,"applied":true,"filesProcessed":4,"filesModified":0}`; string(data) != want {
+		t.Errorf("summary event = %s, want %s", data, want)
+	}
+}
+
+func TestLineChanges(t *testing.T) {
+	before := []byte("a\nb\nc\nd\n")
+	after := []byte("a\nB\nc\nd\ne\n")
+	want := []LineChange{
+		{OldLine: 2, NewLine: 2, Old: "b", New: "B"},
+		{NewLine: 5, New: "e"},
+	}
+	if got := lineChanges(before, after); !reflect.DeepEqual(got, want) {
+		t.Errorf("lineChanges() = %+v, want %+v", got, want)
+	}
+}
+
+func TestNewReportEncodesEmptyLists(t *testing.T) {
+	var b strings.Builder
+	if err := NewReport().WriteJSON(&b); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(b.String(), "null") {
+		t.Errorf("WriteJSON() = %s, want empty lists instead of null", b.String())
+	}
+}
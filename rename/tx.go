@@ -0,0 +1,54 @@
+package rename
+
+import (
+	"errors"
+	"fmt"
+)
+
+// transaction records how to undo every applied step of a plan
+// Until commit is called, rollback restores the original layout and file contents
+type transaction struct {
+	undo     []func() error
+	onCommit []func() error
+	done     bool
+}
+
+// onUndo registers fn to run on rollback, steps are undone in reverse order
+func (t *transaction) onUndo(fn func() error) {
+	t.undo = append(t.undo, fn)
+}
+
+// rollback undoes every applied step and reports the steps that could not be undone
+func (t *transaction) rollback() error {
+	if t.done {
+		return nil
+	}
+	t.done = true
+
+	var errs []error
+	for i := len(t.undo) - 1; i >= 0; i-- {
+		if err := t.undo[i](); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("rollback incomplete: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// commit makes the applied steps permanent and removes leftovers such as replaced directories
+func (t *transaction) commit() error {
+	if t.done {
+		return nil
+	}
+	t.done = true
+
+	var errs []error
+	for _, fn := range t.onCommit {
+		if err := fn(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,116 @@
+package rename
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// updateVendorManifest returns vendor/modules.txt with oldModule and the modules and packages
+// under it renamed to newModule, along with the renamed module paths (each listed once)
+// Module lines look like "# path version [=> replacement [version]]", "## " lines hold
+// annotations and every other line is a vendored package path
+func updateVendorManifest(data []byte, oldModule, newModule string, keep []string) ([]byte, []string) {
+	lines := strings.SplitAfter(string(data), "\n")
+	var modules []string
+	for i, line := range lines {
+		text := strings.TrimRight(line, "\r\n")
+		eol := line[len(text):]
+
+		switch {
+		case strings.HasPrefix(text, "## "):
+		case strings.HasPrefix(text, "# "):
+			fields := strings.Fields(text[2:])
+			changed := false
+			for j, field := range fields {
+				if newPath, ok := renameModulePath(field, oldModule, newModule, keep); ok {
+					if j == 0 && !slices.Contains(modules, field) {
+						modules = append(modules, field)
+					}
+					fields[j] = newPath
+					changed = true
+				}
+			}
+			if changed {
+				lines[i] = "# " + strings.Join(fields, " ") + eol
+			}
+		default:
+			if newPath, ok := renameModulePath(text, oldModule, newModule, keep); ok {
+				lines[i] = newPath + eol
+			}
+		}
+	}
+	return []byte(strings.Join(lines, "")), modules
+}
+
+// planVendorRename plans renaming oldModule in the vendor directory of a consumer module
+// modules.txt is updated, the vendored copies move to their new path and their imports of
+// oldModule are rewritten. Nothing happens if vendorDir has no modules.txt.
+func planVendorRename(ctx context.Context, plan *renamePlan, vendorDir, oldModule, newModule string, keep []string) error {
+	manifest := filepath.Join(vendorDir, "modules.txt")
+	data, err := os.ReadFile(manifest)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	_, modules := updateVendorManifest(data, oldModule, newModule, keep)
+	if err := planFileUpdate(plan, manifest, func(data []byte) ([]byte, error) {
+		updated, _ := updateVendorManifest(data, oldModule, newModule, keep)
+		return updated, nil
+	}); err != nil {
+		return err
+	}
+
+	// A module vendored inside another renamed module moves along with it
+	sort.Strings(modules)
+	var moved []dirMove
+	for _, mod := range modules {
+		from := filepath.Join(vendorDir, filepath.FromSlash(mod))
+		if len(moved) > 0 && strings.HasPrefix(from, moved[len(moved)-1].from+string(filepath.Separator)) {
+			continue
+		}
+		if _, err := os.Stat(from); err != nil {
+			continue
+		}
+		newPath, _ := renameModulePath(mod, oldModule, newModule, keep)
+		to := filepath.Join(vendorDir, filepath.FromSlash(newPath))
+		if _, err := os.Stat(to); err == nil {
+			return fmt.Errorf("vendored module %s already exists", to)
+		}
+		moved = append(moved, dirMove{from: from, to: to})
+	}
+	plan.addMoves(moved...)
+
+	var files []goFile
+	for _, m := range moved {
+		err := walkGoFiles(m.from, nil, func(path string, info fs.FileInfo) error {
+			files = append(files, goFile{path: path, info: info})
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	// Vendored copies are rewritten in place, never reformatted
+	needle := []byte(oldModule)
+	return plan.planRewrites(ctx, files, func(path string, data []byte) (rewriteResult, error) {
+		if !bytes.Contains(data, needle) {
+			return rewriteResult{after: data}, nil
+		}
+		updated, err := replaceModuleImports(string(data), oldModule, newModule, keep...)
+		if err != nil {
+			return skipFile(path, data, err), nil
+		}
+		return rewriteResult{after: []byte(updated)}, nil
+	})
+}
@@ -0,0 +1,100 @@
+package rename
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestUpdateVendorManifest(t *testing.T) {
+	input := `# github.com/pillar/chrop v0.1.0 => ../chrop
+## explicit; go 1.22
+github.com/pillar/chrop
+github.com/pillar/chrop/di
+# github.com/pillar/chrop/tools v0.1.0
+## explicit
+github.com/pillar/chrop/tools/gen
+# github.com/pillar/chropx v1.0.0
+## explicit
+github.com/pillar/chropx
+# github.com/pillar/chrop => ../chrop
+`
+	expected := `# github.com/pillar/doaddon v0.1.0 => ../chrop
+## explicit; go 1.22
+github.com/pillar/doaddon
+github.com/pillar/doaddon/di
+# github.com/pillar/chrop/tools v0.1.0
+## explicit
+github.com/pillar/chrop/tools/gen
+# github.com/pillar/chropx v1.0.0
+## explicit
+github.com/pillar/chropx
+# github.com/pillar/doaddon => ../chrop
+`
+
+	result, modules := updateVendorManifest([]byte(input), "github.com/pillar/chrop", "github.com/pillar/doaddon", []string{"github.com/pillar/chrop/tools"})
+	if string(result) != expected {
+		t.Errorf("updateVendorManifest() = \n%s\n, want \n%s", result, expected)
+	}
+	if want := []string{"github.com/pillar/chrop"}; !reflect.DeepEqual(modules, want) {
+		t.Errorf("updateVendorManifest() modules = %v, want %v", modules, want)
+	}
+}
+
+func TestPlanModuleRenameVendor(t *testing.T) {
+	t.Chdir(t.TempDir())
+	t.Setenv("GOWORK", "")
+	writeTree(t, map[string]string{
+		"go.work":        "go 1.22\n\nuse (\n\t./chrop\n\t./app\n)\n",
+		"chrop/go.mod":   "module github.com/pillar/chrop\n\ngo 1.22\n",
+		"chrop/di/di.go": "package di\n",
+		// Third-party code vendored by the renamed module itself is never rewritten
+		"chrop/vendor/modules.txt":                      "# example.com/lib v1.0.0\n## explicit\nexample.com/lib\n",
+		"chrop/vendor/example.com/lib/lib.go":           "package lib\n\nimport   \"github.com/pillar/chrop/di\"\n",
+		"app/go.mod":                                    "module github.com/pillar/app\n\ngo 1.22\n\nrequire github.com/pillar/chrop v0.1.0\n",
+		"app/main.go":                                   "package main\n\nimport \"github.com/pillar/chrop/di\"\n",
+		"app/vendor/modules.txt":                        "# github.com/pillar/chrop v0.1.0\n## explicit; go 1.22\ngithub.com/pillar/chrop/di\ngithub.com/pillar/chrop/svc\n",
+		"app/vendor/github.com/pillar/chrop/di/di.go":   "package di\n",
+		"app/vendor/github.com/pillar/chrop/svc/svc.go": "package svc\n\nimport \"github.com/pillar/chrop/di\"\n",
+	})
+	t.Chdir("chrop")
+
+	plan := &renamePlan{}
+	if _, err := planModuleRename(t.Context(), plan, Options{Module: "github.com/pillar/doaddon"}, nil, "github.com/pillar/chrop", nil); err != nil {
+		t.Fatalf("planModuleRename() error = %v", err)
+	}
+
+	vendorDir := filepath.Join("..", "app", "vendor")
+	wantMoves := []dirMove{{
+		from: filepath.Join(vendorDir, "github.com", "pillar", "chrop"),
+		to:   filepath.Join(vendorDir, "github.com", "pillar", "doaddon"),
+	}}
+	if !reflect.DeepEqual(plan.moves, wantMoves) {
+		t.Errorf("planned moves = %v, want %v", plan.moves, wantMoves)
+	}
+
+	expected := map[string]string{
+		"go.mod":                                "module github.com/pillar/doaddon\n\ngo 1.22\n",
+		"../app/main.go":                        "package main\n\nimport \"github.com/pillar/doaddon/di\"\n",
+		"../app/go.mod":                         "module github.com/pillar/app\n\ngo 1.22\n\nrequire github.com/pillar/doaddon v0.1.0\n",
+		filepath.Join(vendorDir, "modules.txt"): "# github.com/pillar/doaddon v0.1.0\n## explicit; go 1.22\ngithub.com/pillar/doaddon/di\ngithub.com/pillar/doaddon/svc\n",
+		filepath.Join(vendorDir, "github.com", "pillar", "chrop", "svc", "svc.go"): "package svc\n\nimport \"github.com/pillar/doaddon/di\"\n",
+	}
+	if len(plan.files) != len(expected) {
+		t.Errorf("planned %d files, want %d", len(plan.files), len(expected))
+	}
+	for _, f := range plan.files {
+		want, ok := expected[f.path]
+		if !ok {
+			t.Errorf("unexpected change to %s", f.path)
+			continue
+		}
+		if string(f.after) != want {
+			t.Errorf("%s = \n%s\n, want \n%s", f.path, f.after, want)
+		}
+		if f.path == filepath.Join(vendorDir, "github.com", "pillar", "chrop", "svc", "svc.go") &&
+			f.newPath != filepath.Join(vendorDir, "github.com", "pillar", "doaddon", "svc", "svc.go") {
+			t.Errorf("%s moves to %s, want it under the renamed vendor directory", f.path, f.newPath)
+		}
+	}
+}
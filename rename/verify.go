@@ -0,0 +1,128 @@
+package rename
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// verifyBuild runs go build and then go vet over every module the applied plan touched
+// It returns an error listing the reported problems, the ones in files touched by the rename first
+func verifyBuild(plan *renamePlan) error {
+	touched := make(map[string]bool)
+	for _, f := range plan.files {
+		touched[filepath.Clean(f.newPath)] = true
+	}
+
+	var problems, elsewhere []string
+	for _, dir := range touchedModules(plan) {
+		for _, args := range [][]string{
+			{"build", "-o", os.DevNull, "./..."},
+			{"vet", "./..."},
+		} {
+			cmd := exec.Command("go", args...)
+			cmd.Dir = dir
+			out, err := cmd.CombinedOutput()
+			if err == nil {
+				continue
+			}
+			if _, ok := err.(*exec.ExitError); !ok {
+				return fmt.Errorf("failed to run go %s: %v", args[0], err)
+			}
+
+			if len(strings.TrimSpace(string(out))) == 0 {
+				elsewhere = append(elsewhere, fmt.Sprintf("go %s: %v", args[0], err))
+			}
+			for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+				// Continuation lines of a multi-line error are indented
+				line = strings.TrimSpace(line)
+				if line == "" || strings.HasPrefix(line, "#") {
+					continue
+				}
+				// Positions look like "dir/file.go:12:3: message", vet may prefix them with "vet: "
+				line = strings.TrimPrefix(line, "vet: ")
+				file, rest, ok := strings.Cut(line, ".go:")
+				if !ok {
+					elsewhere = append(elsewhere, line)
+					continue
+				}
+				path := filepath.Join(dir, filepath.FromSlash(file+".go"))
+				line = path + ":" + rest
+				if touched[path] || isMovedTarget(plan, path) {
+					problems = append(problems, line)
+				} else {
+					elsewhere = append(elsewhere, line)
+				}
+			}
+			// go vet would only repeat the type errors of a failed build
+			break
+		}
+	}
+	if len(problems) == 0 && len(elsewhere) == 0 {
+		return nil
+	}
+
+	var b strings.Builder
+	b.WriteString("verification failed")
+	if len(problems) > 0 {
+		b.WriteString("\n  In files touched by the rename:")
+		for _, p := range dedupe(problems) {
+			b.WriteString("\n    " + p)
+		}
+	}
+	if len(elsewhere) > 0 {
+		b.WriteString("\n  Elsewhere:")
+		for _, p := range dedupe(elsewhere) {
+			b.WriteString("\n    " + p)
+		}
+	}
+	return fmt.Errorf("%s", b.String())
+}
+
+// touchedModules returns the root directories of the modules holding a file of plan,
+// the module root of the plan comes first
+func touchedModules(plan *renamePlan) []string {
+	root := plan.path(".")
+	dirs := []string{root}
+	seen := map[string]bool{root: true}
+	for _, f := range plan.files {
+		for dir := filepath.Dir(f.newPath); ; dir = filepath.Dir(dir) {
+			if isModuleRoot(dir) {
+				if !seen[dir] {
+					seen[dir] = true
+					dirs = append(dirs, dir)
+				}
+				break
+			}
+			if dir == "." || filepath.Dir(dir) == dir {
+				break
+			}
+		}
+	}
+	return dirs
+}
+
+// isMovedTarget reports whether path lies in a directory the plan moved files into
+func isMovedTarget(plan *renamePlan, path string) bool {
+	for _, m := range plan.moves {
+		if path == filepath.Clean(m.to) || strings.HasPrefix(path, filepath.Clean(m.to)+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// dedupe returns lines without repeats, keeping the order they were reported in
+func dedupe(lines []string) []string {
+	seen := make(map[string]bool)
+	var out []string
+	for _, l := range lines {
+		if !seen[l] {
+			seen[l] = true
+			out = append(out, l)
+		}
+	}
+	return out
+}
@@ -0,0 +1,82 @@
+package rename
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestRunPlanVerify(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go not installed")
+	}
+	t.Chdir(t.TempDir())
+	t.Setenv("GOWORK", "off")
+	original := map[string]string{
+		"go.mod":      "module example.com/app\n\ngo 1.22\n",
+		"old/a.go":    "package old\n\nfunc A() int { return 1 }\n",
+		"cmd/main.go": "package main\n\nimport \"example.com/app/old\"\n\nfunc main() { _ = old.A() }\n",
+		"lib/lib.go":  "package lib\n",
+	}
+	writeTree(t, original)
+
+	// The importer is moved to the new path but keeps using the old package name without an alias
+	broken := &renamePlan{
+		moves: []dirMove{{from: "old", to: "renamed"}},
+		files: []fileChange{
+			{path: "old/a.go", newPath: "renamed/a.go", mode: 0644, before: []byte(original["old/a.go"]), after: []byte("package renamed\n\nfunc A() int { return 1 }\n")},
+			{path: "cmd/main.go", newPath: "cmd/main.go", mode: 0644, before: []byte(original["cmd/main.go"]), after: []byte("package main\n\nimport \"example.com/app/renamed\"\n\nfunc main() { _ = old.A() }\n")},
+		},
+	}
+	err := runPlan(broken, runOptions{allowDirty: true, verify: true})
+	if err == nil {
+		t.Fatal("runPlan() expected verification error")
+	}
+	for _, want := range []string{"In files touched by the rename:", "cmd/main.go:5", "rolled back"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("runPlan() error = %v, want %q", err, want)
+		}
+	}
+	assertTree(t, original)
+
+	fixed := &renamePlan{
+		moves: broken.moves,
+		files: []fileChange{
+			broken.files[0],
+			{path: "cmd/main.go", newPath: "cmd/main.go", mode: 0644, before: []byte(original["cmd/main.go"]), after: []byte("package main\n\nimport \"example.com/app/renamed\"\n\nfunc main() { _ = renamed.A() }\n")},
+		},
+	}
+	if err := runPlan(fixed, runOptions{allowDirty: true, verify: true}); err != nil {
+		t.Fatalf("runPlan() error = %v", err)
+	}
+}
+
+func TestRunPlanVerifyMultiLineError(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go not installed")
+	}
+	t.Chdir(t.TempDir())
+	t.Setenv("GOWORK", "off")
+	original := map[string]string{
+		"go.mod":     "module example.com/app\n\ngo 1.22\n",
+		"c/a.go":     "package c\n\nvar a = 1\n",
+		"c/other.go": "package c\n\nvar bb = 2\n",
+	}
+	writeTree(t, original)
+
+	// The redeclaration is reported in c/other.go, the touched c/a.go only on the indented line after it
+	plan := &renamePlan{
+		files: []fileChange{
+			{path: "c/a.go", newPath: "c/a.go", mode: 0644, before: []byte(original["c/a.go"]), after: []byte("package c\n\nvar bb = 1\n")},
+		},
+	}
+	err := runPlan(plan, runOptions{allowDirty: true, verify: true})
+	if err == nil {
+		t.Fatal("runPlan() expected verification error")
+	}
+	touched, elsewhere, _ := strings.Cut(err.Error(), "Elsewhere:")
+	if !strings.Contains(touched, "c/a.go:3") || strings.Contains(elsewhere, "c/a.go") {
+		t.Errorf("runPlan() error = %v, want c/a.go among the touched files", err)
+	}
+	assertTree(t, original)
+}
@@ -0,0 +1,59 @@
+package rename
+
+import (
+	"io/fs"
+	"path/filepath"
+	"strings"
+)
+
+// walkGoFiles calls fn for every .go file of the module rooted at root
+// Nested modules (subdirectories with their own go.mod) are not entered, walk them separately
+// Directories and files left out by filter are skipped, a nil filter applies the defaults
+func walkGoFiles(root string, filter *walkFilter, fn func(path string, info fs.FileInfo) error) error {
+	if filter == nil {
+		filter = &walkFilter{}
+	}
+	filter.init()
+	return filepath.Walk(root, func(path string, info fs.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if path == root {
+				return nil
+			}
+			if filter.skipDir(path) || isModuleRoot(path) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasSuffix(path, ".go") || filter.skipFile(path) {
+			return nil
+		}
+		return fn(path, info)
+	})
+}
+
+// hasGoFiles reports whether dir directly holds .go files, a directory of subpackages only has none
+func hasGoFiles(dir string) bool {
+	files, _ := filepath.Glob(filepath.Join(dir, "*.go"))
+	return len(files) > 0
+}
+
+// hasNestedPackages reports whether any subdirectory of dir contains .go files of the same module
+func hasNestedPackages(dir string) bool {
+	found := false
+	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || found {
+			return filepath.SkipAll
+		}
+		if d.IsDir() && path != dir && isModuleRoot(path) {
+			return filepath.SkipDir
+		}
+		if !d.IsDir() && strings.HasSuffix(path, ".go") && filepath.Dir(path) != filepath.Clean(dir) {
+			found = true
+		}
+		return nil
+	})
+	return found
+}